@@ -8,25 +8,128 @@ import (
 )
 
 type FullConfig struct {
-	Gin   GinConfig   `yaml:"gin"`
-	Auth  AuthConfig  `yaml:"auth"`
-	Cache CacheConfig `yaml:"cache"`
-	Mongo MongoConfig `yaml:"mongo"`
+	Gin       GinConfig       `yaml:"gin"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Cache     CacheConfig     `yaml:"cache"`
+	Mongo     MongoConfig     `yaml:"mongo"`
+	Mail      MailConfig      `yaml:"mail"`
+	Storage   StorageConfig   `yaml:"storage"`
+	Account   AccountConfig   `yaml:"account"`
+	WebAuthn  WebAuthnConfig  `yaml:"webauthn"`
+	OIDC      OIDCConfig      `yaml:"oidc"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Export    ExportConfig    `yaml:"export"`
 }
 
 type GinConfig struct {
 	Domain        string   `yaml:"domain"`
 	SigningDomain string   `yaml:"signing_domain"`
+	PublicURL     string   `yaml:"public_url"`
 	Port          string   `yaml:"port"`
 	Env           string   `yaml:"env"`
 	Origins       []string `yaml:"origins"`
+
+	// TrustedProxies are the addresses or CIDR ranges of the reverse
+	// proxies whose X-Forwarded-For header is used as the client IP
+	// address. No proxy is trusted by default.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 type AuthConfig struct {
+	// AccessTokenKeyFile is a PEM encoded RSA, Ed25519 or P-256 private
+	// key used to sign access tokens. If empty, access tokens are signed
+	// with the AccessTokenPub secret instead.
+	AccessTokenKeyFile string `yaml:"access_token_key_file"`
+	AccessTokenKeyID   string `yaml:"access_token_kid"`
+
+	// AccessTokenKeys is the access token keyring. The key matching
+	// AccessTokenKeyID signs new tokens, every other key must be retired
+	// and only verifies tokens until its retirement window has passed.
+	AccessTokenKeys []TokenKeyConfig `yaml:"access_token_keys"`
+
 	AccessTokenPub  string `yaml:"access_token_pub"`
 	AccessTokenTTL  int    `yaml:"access_token_ttl"`
 	RefreshTokenPub string `yaml:"refresh_token_pub"`
 	RefreshTokenTTL int    `yaml:"refresh_token_ttl"`
+
+	// ConfirmationTTL is the lifetime of a confirmation ID in minutes and
+	// ConfirmationCooldown the minimum number of minutes between resends.
+	ConfirmationTTL      int `yaml:"confirmation_ttl"`
+	ConfirmationCooldown int `yaml:"confirmation_cooldown"`
+
+	// EmailRevertTTL is the lifetime in minutes of the link sent to the
+	// previous address of an account to revert an email change. The
+	// previous address is held back from other accounts for as long.
+	EmailRevertTTL int `yaml:"email_revert_ttl"`
+
+	// PasswordResetURL is the client page a reset token is appended to
+	// and PasswordResetTTL the token lifetime in minutes.
+	PasswordResetURL string `yaml:"password_reset_url"`
+	PasswordResetTTL int    `yaml:"password_reset_ttl"`
+
+	// MFASecretKey encrypts TOTP secrets at rest, MFAIssuer is shown in
+	// authenticator apps and MFATokenTTL is the lifetime in minutes of
+	// the token exchanged for a token pair during a login step-up, which
+	// is signed with MFATokenKey.
+	MFASecretKey string `yaml:"mfa_secret_key"`
+	MFAIssuer    string `yaml:"mfa_issuer"`
+	MFATokenKey  string `yaml:"mfa_token_key"`
+	MFATokenTTL  int    `yaml:"mfa_token_ttl"`
+
+	// ReauthMaxAge is the number of minutes after signing in during which
+	// an account without a password may make sensitive changes. Later
+	// changes require signing in again, 5 minutes if zero.
+	ReauthMaxAge int `yaml:"reauth_max_age"`
+
+	LoginThrottle   LoginThrottleConfig   `yaml:"login_throttle"`
+	PasswordHashing PasswordHashingConfig `yaml:"password_hashing"`
+	PasswordPolicy  PasswordPolicyConfig  `yaml:"password_policy"`
+}
+
+// LoginThrottleConfig limits failed password logins. Once an account or
+// IP address reaches its failure limit within the window it is locked
+// for the base lockout, doubling with every further failure up to the
+// maximum lockout.
+type LoginThrottleConfig struct {
+	MaxAccountFailures int `yaml:"max_account_failures"`
+	MaxIPFailures      int `yaml:"max_ip_failures"`
+	Window             int `yaml:"window"`       // Minutes
+	BaseLockout        int `yaml:"base_lockout"` // Seconds
+	MaxLockout         int `yaml:"max_lockout"`  // Seconds
+}
+
+// PasswordHashingConfig selects the algorithm new passwords are hashed
+// with. Stored hashes using another algorithm or weaker parameters are
+// upgraded on the next successful login.
+type PasswordHashingConfig struct {
+	Algorithm  string       `yaml:"algorithm"` // argon2id or bcrypt
+	Argon2     Argon2Config `yaml:"argon2"`
+	BcryptCost int          `yaml:"bcrypt_cost"`
+}
+
+// PasswordPolicyConfig limits the passwords accounts may choose. Lengths
+// are counted in characters, BreachDirectory holds the breached password
+// dataset split by SHA-1 prefix and disables the breach check if empty.
+type PasswordPolicyConfig struct {
+	MinLength           int    `yaml:"min_length"`
+	MaxLength           int    `yaml:"max_length"`
+	CommonPasswordsFile string `yaml:"common_passwords_file"` // One password per line, extends the built-in list
+	BreachDirectory     string `yaml:"breach_directory"`
+}
+
+type Argon2Config struct {
+	Memory      uint32 `yaml:"memory"` // KiB
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+	SaltLength  uint32 `yaml:"salt_length"` // Bytes
+	KeyLength   uint32 `yaml:"key_length"`  // Bytes
+}
+
+type TokenKeyConfig struct {
+	ID        string `yaml:"kid"`
+	File      string `yaml:"file"`       // PEM private key, or public key for retired keys
+	Secret    string `yaml:"secret"`     // HMAC secret, used when no file is set
+	RetiredAt string `yaml:"retired_at"` // RFC 3339, required for every key but the current one
 }
 
 type CacheConfig struct {
@@ -40,6 +143,111 @@ type MongoConfig struct {
 	DatabaseName string `yaml:"database_name"`
 }
 
+type MailConfig struct {
+	Driver    string       `yaml:"driver"` // smtp, file or memory
+	From      string       `yaml:"from"`
+	Directory string       `yaml:"directory"` // Output directory of the file driver, stdout if empty
+	SMTP      SMTPConfig   `yaml:"smtp"`
+	Outbox    OutboxConfig `yaml:"outbox"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type OutboxConfig struct {
+	Interval    int `yaml:"interval"` // Seconds between outbox polls
+	MaxAttempts int `yaml:"max_attempts"`
+}
+
+type StorageConfig struct {
+	Driver string             `yaml:"driver"` // local or s3
+	Local  LocalStorageConfig `yaml:"local"`
+	S3     S3StorageConfig    `yaml:"s3"`
+}
+
+type LocalStorageConfig struct {
+	Directory string `yaml:"directory"`
+	BaseURL   string `yaml:"base_url"`
+	Route     string `yaml:"route"` // Route the directory is served from, disabled if empty
+}
+
+type S3StorageConfig struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PublicURL string `yaml:"public_url"`
+	PathStyle bool   `yaml:"path_style"`
+}
+
+type AccountConfig struct {
+	AvatarMaxSize int64 `yaml:"avatar_max_size"` // Bytes
+
+	// DeletionGracePeriod is the number of days a deletion can be
+	// cancelled, IdentifierCooldown the number of days the username and
+	// email of a deleted account can not be reused and PurgeInterval the
+	// minutes between runs of the purge job.
+	DeletionGracePeriod int `yaml:"deletion_grace_period"`
+	IdentifierCooldown  int `yaml:"identifier_cooldown"`
+	PurgeInterval       int `yaml:"purge_interval"`
+
+	// UsernameChangeLimit is the number of username changes allowed per
+	// UsernameChangePeriod days, unlimited if zero. A previous username
+	// is held back from other accounts for UsernameCooldown days.
+	UsernameChangeLimit  int `yaml:"username_change_limit"`
+	UsernameChangePeriod int `yaml:"username_change_period"`
+	UsernameCooldown     int `yaml:"username_cooldown"`
+}
+
+type WebAuthnConfig struct {
+	RPID         string   `yaml:"rp_id"`         // Registrable domain passkeys are scoped to
+	RPName       string   `yaml:"rp_name"`       // Name shown by authenticators
+	Origins      []string `yaml:"origins"`       // Origins ceremonies may be performed from
+	ChallengeTTL int      `yaml:"challenge_ttl"` // Minutes
+}
+
+type OIDCConfig struct {
+	StateTTL  int                           `yaml:"state_ttl"` // Minutes
+	Providers map[string]OIDCProviderConfig `yaml:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	Issuer       string   `yaml:"issuer"` // Endpoints are discovered from the issuer
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"` // Client page forwarding code and state to the callback
+	Scopes       []string `yaml:"scopes"`       // Defaults to openid, email and profile
+}
+
+// ExportConfig controls personal data exports. Archives are kept in
+// their own Storage, which is never served publicly. Download links are
+// signed with SigningKey and never outlive the archive they point to.
+type ExportConfig struct {
+	Interval   int           `yaml:"interval"`    // Seconds between export job polls
+	ArchiveTTL int           `yaml:"archive_ttl"` // Hours an archive is kept
+	LinkTTL    int           `yaml:"link_ttl"`    // Minutes a download link is valid
+	SigningKey string        `yaml:"signing_key"`
+	Storage    StorageConfig `yaml:"storage"`
+}
+
+type RateLimitConfig struct {
+	Policies map[string]RateLimitPolicy `yaml:"policies"`
+}
+
+// RateLimitPolicy allows Limit requests per Window seconds for every
+// client identified by Key, which is either "ip" or "account". Clients
+// without an account are identified by their IP address.
+type RateLimitPolicy struct {
+	Limit  int    `yaml:"limit"`
+	Window int    `yaml:"window"` // Seconds
+	Key    string `yaml:"key"`
+}
+
 // GetConfig reads all configurable values
 // located in /bin/config.toml in to a FullConfig object
 func GetConfig() *FullConfig {
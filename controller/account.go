@@ -1,9 +1,10 @@
 package controller
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"reflect"
 	"tc-server/db"
@@ -28,18 +29,58 @@ func (c *GlobalController) ApplyAccountRoutes(router *gin.Engine) {
 		CollectionName:   "account",
 	}
 
+	limiter := c.Limiter
+	auth := limiter.Limit("auth")
+
 	pub := router.Group("/v1/account")
 	{
-		pub.GET("/availability/:key/:value", ac.GetAccountAvailability()) // Return if an account field is in available
-		pub.GET("/confirm/:confirmId", ac.Confirm())                      // Confirm a confirmation for email or phone
-		pub.POST("/", ac.CreateAccount())                                 // Create a new account
+		pub.GET("/availability/:key/:value", limiter.Limit("availability"), ac.GetAccountAvailability()) // Return if an account field is in available
+		pub.GET("/confirm/:confirmId", auth, ac.Confirm())                                               // Confirm a confirmation for email or phone
+		pub.POST("/", auth, ac.CreateAccount())                                                          // Create a new account
+		pub.POST("/login", auth, ac.Login())                                                             // Exchange account credentials for a new token pair
+		pub.POST("/login/mfa", auth, ac.LoginMFA())                                                      // Exchange an mfa token and second factor for a new token pair
+		pub.POST("/passkeys/login/begin", auth, ac.BeginPasskeyLogin())                                  // Start a passkey login ceremony
+		pub.POST("/passkeys/login/finish", auth, ac.FinishPasskeyLogin())                                // Exchange a passkey assertion for a new token pair
+		pub.POST("/oidc/:provider/authorize", auth, ac.AuthorizeOIDC())                                  // Start a sign-in with an OpenID Connect provider
+		pub.POST("/oidc/:provider/callback", auth, ac.OIDCCallback())                                    // Complete a provider sign-in
+		pub.POST("/refresh", auth, ac.RefreshToken())                                                    // Rotate a refresh token for a new token pair
+		pub.POST("/logout", ac.Logout())                                                                 // Revoke the current refresh token
+		pub.POST("/password/forgot", auth, ac.ForgotPassword())                                          // Request a password reset link
+		pub.POST("/password/reset", auth, ac.ResetPassword())                                            // Replace the password using a reset token
+		pub.GET("/exports/:exportId/download", ac.DownloadExport())                                      // Download a data export through a signed link
 	}
 
 	priv := router.Group("/v1/account")
-	priv.Use(middleware.Authorize(ac.GlobalController.Config))
+	priv.Use(
+		middleware.Authorize(ac.GlobalController.AccessKeys, ac.GlobalController.Redis),
+		limiter.Limit("account"),
+	)
 	{
-		priv.GET("/", ac.GetAccountByToken())               // Return account matching request token
-		priv.GET("/:key/:value", ac.GetAccountByKeyValue()) // Return simple account info matching the provided key/value combo
+		priv.POST("/logout/all", ac.LogoutAll())                               // Revoke every token issued to the account
+		priv.POST("/deletion", ac.RequestDeletion())                           // Schedule the account for deletion
+		priv.DELETE("/deletion", ac.CancelDeletion())                          // Cancel the pending deletion of the account
+		priv.POST("/exports", limiter.Limit("export"), ac.RequestExport())     // Queue an export of the account data
+		priv.GET("/exports", ac.ListExports())                                 // Return the data exports of the account
+		priv.GET("/sessions", ac.ListSessions())                               // Return the active sessions of the account
+		priv.DELETE("/sessions/:sessionId", ac.RevokeSession())                // Sign the account out of a session
+		priv.POST("/confirm/resend", ac.ResendConfirmation())                  // Resend the email confirmation
+		priv.PUT("/email", ac.ChangeEmail())                                   // Request a change of the email address
+		priv.PUT("/username", ac.ChangeUsername())                             // Change the username of the account
+		priv.GET("/", ac.GetAccountByToken())                                  // Return account matching request token
+		priv.PATCH("/profile", ac.UpdateProfile())                             // Update the profile of the account matching request token
+		priv.POST("/profile/avatar", ac.UploadAvatar())                        // Upload a new avatar image
+		priv.POST("/mfa/totp", ac.EnrollTOTP())                                // Generate a pending TOTP secret
+		priv.POST("/mfa/totp/verify", ac.VerifyTOTP())                         // Activate the pending TOTP secret
+		priv.DELETE("/mfa/totp", ac.DisableTOTP())                             // Disable two-factor authentication
+		priv.POST("/mfa/recovery", ac.RegenerateRecoveryCodes())               // Replace the recovery codes
+		priv.GET("/passkeys", ac.ListPasskeys())                               // Return the passkeys of the account
+		priv.POST("/passkeys/register/begin", ac.BeginPasskeyRegistration())   // Start a passkey registration ceremony
+		priv.POST("/passkeys/register/finish", ac.FinishPasskeyRegistration()) // Store the passkey created by the registration ceremony
+		priv.DELETE("/passkeys/:credentialId", ac.DeletePasskey())             // Remove a passkey
+		priv.POST("/oidc/:provider/link", ac.LinkOIDC())                       // Start linking an OpenID Connect provider
+		priv.POST("/oidc/:provider/link/callback", ac.LinkOIDCCallback())      // Complete linking an OpenID Connect provider
+		priv.DELETE("/oidc/:provider", ac.UnlinkOIDC())                        // Unlink an OpenID Connect provider
+		priv.GET("/:key/:value", ac.GetAccountByKeyValue())                    // Return simple account info matching the provided key/value combo
 	}
 }
 
@@ -67,13 +108,24 @@ func (ac *AccountController) GetAccountAvailability() gin.HandlerFunc {
 			return
 		}
 
+		reserved, err := ac.identifierReserved(key, value)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if reserved {
+			ctx.Status(http.StatusConflict)
+			return
+		}
+
 		// We need to append .value here to properly query it
 		// within MongoDB since it is stored in a Confirmable entry.
 		if key == "email" {
 			key = "email.value"
 		}
 
-		_, err := db.FindDocumentByKeyValue[string, model.Account](db.MongoParams{
+		_, err = db.FindDocumentByKeyValue[string, model.Account](db.MongoParams{
 			Client:         ac.GlobalController.Mongo,
 			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
 			CollectionName: ac.CollectionName,
@@ -93,26 +145,15 @@ func (ac *AccountController) GetAccountAvailability() gin.HandlerFunc {
 	}
 }
 
-// Confirm will parse a confirmation ID and confirm the provided
-// account information matching the confirmation object in cache.
-func (ac *AccountController) Confirm() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
-		ctx.Status(http.StatusNotImplemented)
-	}
-}
-
 // CreateAccount attempts to create a new Training Club account
 func (ac *AccountController) CreateAccount() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
 		mongop := db.MongoParams{
 			Client:         ac.GlobalController.Mongo,
 			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
 			CollectionName: ac.CollectionName,
 		}
 
-		debug := ac.GlobalController.Config.Gin.Env == "debug"
-
 		var req request.CreateAccountRequest
 		err := ctx.ShouldBindJSON(&req)
 		if err != nil {
@@ -130,8 +171,7 @@ func (ac *AccountController) CreateAccount() gin.HandlerFunc {
 			return
 		}
 
-		if !util.ValidatePassword(req.Password) {
-			util.CreateError(ctx, http.StatusBadRequest, "invalid password")
+		if !ac.validatePassword(ctx, req.Password, req.Username, req.Email) {
 			return
 		}
 
@@ -157,21 +197,38 @@ func (ac *AccountController) CreateAccount() gin.HandlerFunc {
 			return
 		}
 
-		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), 8)
+		// Identifiers of deleted accounts are held back for a cool-off
+		// period before they can be taken again.
+		for _, identifier := range [][2]string{
+			{model.ReservedEmail, req.Email},
+			{model.ReservedUsername, req.Username},
+		} {
+			reserved, err := ac.identifierReserved(identifier[0], identifier[1])
+			if err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			if reserved {
+				util.CreateError(ctx, http.StatusConflict, identifier[0]+" is in use")
+				return
+			}
+		}
+
+		hash, err := ac.GlobalController.Passwords.Hash(req.Password)
 		if err != nil {
 			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate hash: "+err.Error())
 			return
 		}
 
-		pwd := string(hash)
 		insert := model.Account{
 			Username: req.Username,
 			Email: model.AccountConfirmable{
-				Value:       req.Email,
-				Confirmed:   false,
-				ConfirmedAt: time.Now(),
+				Value:     req.Email,
+				Confirmed: false,
 			},
-			Password: pwd,
+			Password: hash,
+			Roles:    []string{model.RoleMember},
 			Metadata: model.AccountMetadata{
 				CreatedAt: time.Now(),
 				LastSeen:  time.Now(),
@@ -184,23 +241,15 @@ func (ac *AccountController) CreateAccount() gin.HandlerFunc {
 			return
 		}
 
-		accesstoken, err := util.GenerateToken(
-			id,
-			ac.GlobalController.Config.Auth.AccessTokenPub,
-			ac.GlobalController.Config.Auth.AccessTokenTTL,
-		)
-		if err != nil {
-			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate access token: "+err.Error())
-			return
+		// A failed confirmation mail does not fail the account creation
+		// since the account owner is able to request a new one.
+		if err := ac.sendEmailConfirmation(id, req.Email); err != nil {
+			fmt.Println("Failed to send email confirmation: " + err.Error())
 		}
 
-		refreshtoken, err := util.GenerateToken(
-			id,
-			ac.GlobalController.Config.Auth.RefreshTokenPub,
-			ac.GlobalController.Config.Auth.RefreshTokenTTL,
-		)
+		accesstoken, refreshtoken, err := ac.issueTokens(ctx, id, insert.Roles, "")
 		if err != nil {
-			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate refresh token: "+err.Error())
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
 			return
 		}
 
@@ -210,46 +259,253 @@ func (ac *AccountController) CreateAccount() gin.HandlerFunc {
 			RefreshToken: refreshtoken,
 		}
 
-		_, err = db.SetCacheValue(redisp, refreshtoken, id, ac.GlobalController.Config.Auth.RefreshTokenTTL)
+		ctx.JSON(http.StatusCreated, res)
+	}
+}
+
+// Login attempts to authenticate an account using either its
+// username or email address alongside the account password. On
+// success the same token pair and refresh cookie issued by
+// CreateAccount are returned.
+func (ac *AccountController) Login() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		var req request.LoginAccountRequest
+		err := ctx.ShouldBindJSON(&req)
 		if err != nil {
-			util.CreateError(ctx, http.StatusInternalServerError, "failed to cache refresh token: "+err.Error())
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
 			return
 		}
 
-		var cookieDomain string
-		if debug {
-			cookieDomain = ac.GlobalController.Config.Gin.SigningDomain
+		if len(req.Identifier) == 0 || len(req.Password) == 0 {
+			util.CreateError(ctx, http.StatusBadRequest, "identifier and password are required")
+			return
+		}
+
+		key := "username"
+		if util.ValidateEmail(req.Identifier) {
+			key = "email.value"
+		} else if !util.ValidateUsername(req.Identifier) {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid username or email")
+			return
+		}
+
+		account, err := db.FindDocumentByKeyValue[string, model.Account](mongop, key, req.Identifier)
+		if err != nil && err != mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		found := err == nil
+		var owner *model.Account
+		if found {
+			owner = &account
+		}
+
+		subjects := ac.loginSubjects(ctx, owner, req.Identifier)
+		if !ac.checkLoginLockout(ctx, subjects) {
+			return
+		}
+
+		// An unknown account still runs a hash comparison so the response
+		// time does not reveal whether the identifier is registered.
+		var valid, rehash bool
+		if found && len(account.Password) > 0 {
+			valid, rehash, err = ac.GlobalController.Passwords.Verify(req.Password, account.Password)
+			if err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, "failed to verify password: "+err.Error())
+				return
+			}
 		} else {
-			cookieDomain = ".localhost"
+			ac.GlobalController.Passwords.VerifyDummy(req.Password)
 		}
 
-		ctx.SetSameSite(http.SameSiteNoneMode)
-		ctx.SetCookie(
-			"refresh_token",
-			refreshtoken,
-			ac.GlobalController.Config.Auth.RefreshTokenTTL,
-			"/",
-			cookieDomain,
-			true,
-			true,
-		)
+		if !valid {
+			if err := ac.recordLoginFailure(ctx, owner, subjects); err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
 
-		ctx.JSON(http.StatusCreated, res)
+			if !ac.checkLoginLockout(ctx, subjects) {
+				return
+			}
+
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+
+		if err := ac.resetLoginFailures(account); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// A failed upgrade does not fail the login since the outdated hash
+		// is still valid and upgraded on a later login.
+		if rehash {
+			if err := ac.rehashPassword(account, req.Password); err != nil {
+				fmt.Println("Failed to upgrade password hash: " + err.Error())
+			}
+		}
+
+		ac.completeLogin(ctx, account)
+	}
+}
+
+// completeLogin responds with a new token pair for an authenticated
+// account. Accounts with a second factor receive a short-lived MFA token
+// instead, which LoginMFA exchanges for the token pair.
+func (ac *AccountController) completeLogin(ctx *gin.Context, account model.Account) {
+	id := account.ID.Hex()
+
+	if account.MFA.Enabled {
+		mfatoken, err := util.GenerateTokenWithClaims(util.Claims{
+			AccountID: id,
+			Scopes:    []string{model.ScopeMFAPending},
+		}, ac.GlobalController.MFAKey, ac.GlobalController.Config.Auth.MFATokenTTL)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate mfa token: "+err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.AccountMFARequiredResponse{
+			ID:          id,
+			MFARequired: true,
+			MFAToken:    mfatoken,
+		})
+		return
+	}
+
+	accesstoken, refreshtoken, err := ac.issueTokens(ctx, id, account.EffectiveRoles(), "")
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return
 	}
+
+	ctx.JSON(http.StatusOK, response.AccountLoginResponse{
+		ID:           id,
+		AccessToken:  accesstoken,
+		RefreshToken: refreshtoken,
+	})
 }
 
 // GetAccountByToken queries the account attached to the requesters
-// token stored in their cookies sent within the request.
+// token stored in their cookies sent within the request. The account
+// last seen timestamp is updated as part of the request.
 func (ac *AccountController) GetAccountByToken() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Status(http.StatusNotImplemented)
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		account, err := db.FindDocumentById[model.Account](mongop, ctx.GetString("accountId"))
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusNotFound, "account not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		now := time.Now()
+		_, err = db.UpdateDocument(mongop, account.ID, "metadata.last_seen_at", now)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to update last seen: "+err.Error())
+			return
+		}
+
+		account.Metadata.LastSeen = now
+		ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
 	}
 }
 
 // GetAccountByKeyValue queries basic account information using
-// the account username or ID.
+// the account username or ID. Private account information is only
+// included when the requester is the account owner. Lookups of a
+// username that was changed redirect to the new username.
 func (ac *AccountController) GetAccountByKeyValue() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Status(http.StatusNotImplemented)
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		key := ctx.Param("key")
+		value := ctx.Param("value")
+
+		if key != "id" && key != "username" {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid key, expected 'id' or 'username'")
+			return
+		}
+
+		if key == "username" && !util.ValidateUsername(value) {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid username")
+			return
+		}
+
+		var account model.Account
+		var err error
+		if key == "id" {
+			if !primitive.IsValidObjectID(value) {
+				util.CreateError(ctx, http.StatusBadRequest, "invalid id")
+				return
+			}
+
+			account, err = db.FindDocumentById[model.Account](mongop, value)
+		} else {
+			account, err = db.FindDocumentByKeyValue[string, model.Account](mongop, key, value)
+		}
+
+		// A username that is no longer in use may have been given up by an
+		// account, in which case the client is pointed to its new username.
+		if err == mongo.ErrNoDocuments && key == "username" {
+			previous, err := ac.previousUsernameOwner(value)
+			if err == nil && previous.Deletion == nil {
+				ctx.Header("Location", "/v1/account/username/"+previous.Username)
+				ctx.JSON(http.StatusFound, response.UsernameRedirectResponse{
+					ID:       previous.ID.Hex(),
+					Username: previous.Username,
+				})
+				return
+			}
+
+			if err != nil && err != mongo.ErrNoDocuments {
+				util.CreateError(ctx, http.StatusInternalServerError, "failed to perform username history lookup: "+err.Error())
+				return
+			}
+		}
+
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusNotFound, "account not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		if account.ID.Hex() == ctx.GetString("accountId") {
+			ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
+			return
+		}
+
+		// Accounts pending deletion are hidden from everyone but their
+		// owner.
+		if account.Deletion != nil {
+			util.CreateError(ctx, http.StatusNotFound, "account not found")
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.NewPublicAccountResponse(account))
 	}
 }
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"net/http"
+	"strconv"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/response"
+	"tc-server/util"
+)
+
+// avatarSizes are the edge lengths of the square avatar renditions
+// generated for every upload. The largest is used as the main avatar.
+var avatarSizes = []int{64, 128, 256, 512}
+
+// avatarContentTypes are the sniffed content types accepted as avatars.
+var avatarContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// UploadAvatar accepts a multipart image upload in the avatar field,
+// generates the standard avatar renditions and stores them in the blob
+// store. The profile avatar is replaced with the uploaded image.
+func (ac *AccountController) UploadAvatar() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+		maxSize := ac.GlobalController.Config.Account.AvatarMaxSize
+
+		// Leave some room for the multipart envelope around the file.
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxSize+64*1024)
+
+		file, header, err := ctx.Request.FormFile("avatar")
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				util.CreateError(ctx, http.StatusRequestEntityTooLarge, "avatar exceeds maximum size")
+				return
+			}
+
+			util.CreateError(ctx, http.StatusBadRequest, "missing avatar file: "+err.Error())
+			return
+		}
+		defer file.Close()
+
+		b, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "failed to read avatar file: "+err.Error())
+			return
+		}
+
+		if header.Size > maxSize || int64(len(b)) > maxSize {
+			util.CreateError(ctx, http.StatusRequestEntityTooLarge, "avatar exceeds maximum size")
+			return
+		}
+
+		// The client supplied content type is ignored in favour of
+		// sniffing the actual file contents.
+		if !avatarContentTypes[http.DetectContentType(b)] {
+			util.CreateError(ctx, http.StatusUnsupportedMediaType, "unsupported image type, expected jpeg, png or gif")
+			return
+		}
+
+		thumbnails, contentType, err := util.CreateThumbnails(b, avatarSizes)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid image: "+err.Error())
+			return
+		}
+
+		account, err := db.FindDocumentById[model.Account](mongop, ctx.GetString("accountId"))
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusNotFound, "account not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		version, err := util.GenerateRandomString(8)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate avatar key: "+err.Error())
+			return
+		}
+
+		extension := ".png"
+		if contentType == "image/jpeg" {
+			extension = ".jpg"
+		}
+
+		avatars := make(map[string]string, len(thumbnails))
+		keys := make([]string, 0, len(thumbnails))
+		for _, size := range avatarSizes {
+			key := fmt.Sprintf("avatars/%s/%s/%d%s", account.ID.Hex(), version, size, extension)
+			data := thumbnails[size]
+
+			err := ac.GlobalController.Blobs.Put(ctx.Request.Context(), key, bytes.NewReader(data), int64(len(data)), contentType)
+			if err != nil {
+				ac.deleteAvatarBlobs(keys)
+				util.CreateError(ctx, http.StatusInternalServerError, "failed to store avatar: "+err.Error())
+				return
+			}
+
+			keys = append(keys, key)
+			avatars[strconv.Itoa(size)] = ac.GlobalController.Blobs.URL(key)
+		}
+
+		profile := &account.Metadata.Profile
+		previous := profile.AvatarKeys
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "metadata.profile.avatar", profile.Avatar, avatars[strconv.Itoa(avatarSizes[len(avatarSizes)-1])])
+
+		profile.Avatar = entry.NewValue
+		profile.Avatars = avatars
+		profile.AvatarKeys = keys
+
+		_, err = db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+			"$set": bson.M{
+				"metadata.profile.avatar":      profile.Avatar,
+				"metadata.profile.avatars":     profile.Avatars,
+				"metadata.profile.avatar_keys": profile.AvatarKeys,
+			},
+		})
+		if err != nil {
+			ac.deleteAvatarBlobs(keys)
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to update avatar: "+err.Error())
+			return
+		}
+
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			fmt.Println("Failed to record avatar audit: " + err.Error())
+		}
+
+		ac.deleteAvatarBlobs(previous)
+		ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
+	}
+}
+
+// deleteAvatarBlobs removes previously stored avatar renditions. Failures
+// only leave unreferenced blobs behind, so they are logged and ignored.
+func (ac *AccountController) deleteAvatarBlobs(keys []string) {
+	for _, key := range keys {
+		if err := ac.GlobalController.Blobs.Delete(context.Background(), key); err != nil {
+			fmt.Println("Failed to delete avatar blob: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/util"
+	"time"
+)
+
+const (
+	// confirmationPrefix prefixes the cache key of a pending confirmation.
+	confirmationPrefix = "confirm:"
+
+	// confirmationCooldownPrefix prefixes the cache key preventing an
+	// account from requesting confirmations in quick succession.
+	confirmationCooldownPrefix = "confirm_cooldown:"
+)
+
+// Confirm will parse a confirmation ID and confirm the provided
+// account information matching the confirmation object in cache.
+func (ac *AccountController) Confirm() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		key := confirmationPrefix + ctx.Param("confirmId")
+		raw, err := db.GetCacheValue(redisp, key)
+		if err == redis.Nil {
+			util.CreateError(ctx, http.StatusNotFound, "confirmation not found or expired")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform confirmation lookup: "+err.Error())
+			return
+		}
+
+		var confirmation model.Confirmation
+		err = json.Unmarshal([]byte(raw), &confirmation)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to parse confirmation: "+err.Error())
+			return
+		}
+
+		account, err := db.FindDocumentById[model.Account](mongop, confirmation.AccountID)
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusNotFound, "account not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		switch confirmation.Field {
+		case model.ConfirmEmail:
+			ac.confirmEmail(ctx, key, account, confirmation)
+		case model.ConfirmEmailChange:
+			ac.confirmEmailChange(ctx, key, account, confirmation)
+		case model.ConfirmEmailRevert:
+			ac.revertEmailChange(ctx, key, account, confirmation)
+		default:
+			util.CreateError(ctx, http.StatusBadRequest, "unsupported confirmation field")
+		}
+	}
+}
+
+// confirmEmail marks the current email address of an account as
+// confirmed.
+func (ac *AccountController) confirmEmail(ctx *gin.Context, key string, account model.Account, confirmation model.Confirmation) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	// The address may have changed since the confirmation was sent, in
+	// which case confirming it would mark the wrong address as owned.
+	if account.Email.Value != confirmation.Value {
+		_, _ = db.DeleteCacheValue(redisp, key)
+		util.CreateError(ctx, http.StatusGone, "confirmation no longer matches account")
+		return
+	}
+
+	_, err := db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+		"$set": bson.M{
+			"email.confirmed":    true,
+			"email.confirmed_at": time.Now(),
+		},
+	})
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to confirm email: "+err.Error())
+		return
+	}
+
+	_, err = db.DeleteCacheValue(redisp, key)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to remove confirmation: "+err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// ResendConfirmation sends a new email confirmation to the requesting
+// account. Requests are limited by the configured confirmation cooldown.
+func (ac *AccountController) ResendConfirmation() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		id := ctx.GetString("accountId")
+		account, err := db.FindDocumentById[model.Account](mongop, id)
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusNotFound, "account not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		if account.Email.Confirmed {
+			util.CreateError(ctx, http.StatusConflict, "email is already confirmed")
+			return
+		}
+
+		cooldown := ac.GlobalController.Config.Auth.ConfirmationCooldown * 60
+		ok, err := db.SetCacheValueIfAbsent(redisp, confirmationCooldownPrefix+id, 1, cooldown)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to apply confirmation cooldown: "+err.Error())
+			return
+		}
+
+		if !ok {
+			ctx.Header("Retry-After", fmt.Sprint(cooldown))
+			util.CreateError(ctx, http.StatusTooManyRequests, "confirmation was requested too recently")
+			return
+		}
+
+		if err := ac.sendEmailConfirmation(id, account.Email.Value); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.Status(http.StatusAccepted)
+	}
+}
+
+// sendEmailConfirmation stores a new email confirmation in cache and
+// mails its confirmation link to the provided address.
+func (ac *AccountController) sendEmailConfirmation(accountId string, email string) error {
+	conf := ac.GlobalController.Config
+
+	confirmId, err := ac.storeConfirmation(model.Confirmation{
+		AccountID: accountId,
+		Field:     model.ConfirmEmail,
+		Value:     email,
+	}, conf.Auth.ConfirmationTTL*60)
+	if err != nil {
+		return err
+	}
+
+	err = ac.GlobalController.Mail.Enqueue(email, "confirm_email", map[string]string{
+		"Link": conf.Gin.PublicURL + "/v1/account/confirm/" + confirmId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send confirmation: %w", err)
+	}
+
+	return nil
+}
+
+// storeConfirmation stores a confirmation in cache for the provided
+// number of seconds and returns its confirmation ID.
+func (ac *AccountController) storeConfirmation(confirmation model.Confirmation, ttl int) (string, error) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	confirmId, err := util.GenerateRandomString(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate confirmation id: %w", err)
+	}
+
+	raw, err := json.Marshal(confirmation)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode confirmation: %w", err)
+	}
+
+	_, err = db.SetCacheValue(redisp, confirmationPrefix+confirmId, string(raw), ttl)
+	if err != nil {
+		return "", fmt.Errorf("failed to cache confirmation: %w", err)
+	}
+
+	return confirmId, nil
+}
@@ -0,0 +1,428 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/response"
+	"tc-server/util"
+	"time"
+)
+
+const (
+	reservedCollectionName = "reserved_identifier"
+
+	// purgeTimeout is how long a claimed purge may run before another
+	// run of the purge job retries it.
+	purgeTimeout = 30 * time.Minute
+)
+
+// ownedCollections lists every collection holding documents owned by an
+// account and the field referencing the owner. Documents matching the
+// account are deleted together with it.
+var ownedCollections = []struct {
+	name  string
+	field string
+}{
+	{auditCollectionName, "account_id"},
+	{exportCollectionName, "account_id"},
+	{usernameHistoryCollectionName, "account_id"},
+}
+
+// RequestDeletion schedules the requesting account for deletion after
+// the configured grace period and signs it out everywhere. Signing in
+// again remains possible so the deletion can be cancelled.
+func (ac *AccountController) RequestDeletion() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		var req request.ReauthRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		if account.Deletion != nil {
+			util.CreateError(ctx, http.StatusConflict, "account is already pending deletion")
+			return
+		}
+
+		if !ac.reauthenticate(ctx, account, req) {
+			return
+		}
+
+		now := time.Now()
+		deletion := model.AccountDeletion{
+			RequestedAt: now,
+			ScheduledAt: now.AddDate(0, 0, ac.GlobalController.Config.Account.DeletionGracePeriod),
+		}
+
+		result, err := db.UpdateDocumentMatching(mongop,
+			bson.M{"_id": account.ID, "deletion": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"deletion": deletion}})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to schedule deletion: "+err.Error())
+			return
+		}
+
+		if result.ModifiedCount == 0 {
+			util.CreateError(ctx, http.StatusConflict, "account is already pending deletion")
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "deletion", "", deletion.ScheduledAt.Format(time.RFC3339))
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		if err := ac.revokeAllSessions(account.ID.Hex()); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := ac.GlobalController.Mail.Enqueue(account.Email.Value, "account_deletion", map[string]any{
+			"Date": deletion.ScheduledAt.Format("January 2, 2006"),
+		}); err != nil {
+			fmt.Println("Failed to send deletion notice: " + err.Error())
+		}
+
+		ac.setRefreshCookie(ctx, "", -1)
+		ctx.JSON(http.StatusAccepted, response.AccountDeletionResponse{
+			RequestedAt: deletion.RequestedAt,
+			ScheduledAt: deletion.ScheduledAt,
+		})
+	}
+}
+
+// CancelDeletion cancels the pending deletion of the requesting account.
+// A deletion can no longer be cancelled once its purge has started.
+func (ac *AccountController) CancelDeletion() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		if account.Deletion == nil {
+			util.CreateError(ctx, http.StatusConflict, "account is not pending deletion")
+			return
+		}
+
+		result, err := db.UpdateDocumentMatching(mongop, bson.M{
+			"_id":                       account.ID,
+			"deletion":                  bson.M{"$exists": true},
+			"deletion.purge_started_at": bson.M{"$exists": false},
+		}, bson.M{"$unset": bson.M{"deletion": ""}})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to cancel deletion: "+err.Error())
+			return
+		}
+
+		if result.ModifiedCount == 0 {
+			util.CreateError(ctx, http.StatusConflict, "account deletion can no longer be cancelled")
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "deletion", account.Deletion.ScheduledAt.Format(time.RFC3339), "")
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// RunAccountPurge purges accounts whose deletion grace period has passed
+// until the context is cancelled. Expired identifier reservations are
+// removed on every run.
+func (c *GlobalController) RunAccountPurge(ctx context.Context) {
+	ac := AccountController{
+		GlobalController: c,
+		CollectionName:   "account",
+	}
+
+	interval := time.Duration(c.Config.Account.PurgeInterval) * time.Minute
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ac.purgeDeletedAccounts()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeletedAccounts purges every account that is due.
+func (ac *AccountController) purgeDeletedAccounts() {
+	for {
+		account, err := ac.claimPurge()
+		if err == mongo.ErrNoDocuments {
+			break
+		}
+
+		if err != nil {
+			fmt.Println("Failed to claim account purge: " + err.Error())
+			break
+		}
+
+		if err := ac.purgeAccount(account); err != nil {
+			fmt.Println("Failed to purge account " + account.ID.Hex() + ": " + err.Error())
+		}
+	}
+
+	_, err := db.DeleteManyDocuments(db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: reservedCollectionName,
+	}, bson.M{"expires_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		fmt.Println("Failed to remove expired identifier reservations: " + err.Error())
+	}
+}
+
+// claimPurge marks the next account due for purging as being purged so
+// its deletion can no longer be cancelled. Purges that did not finish
+// within purgeTimeout are claimed again.
+func (ac *AccountController) claimPurge() (model.Account, error) {
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	now := time.Now()
+	return db.FindOneAndUpdateDocument[model.Account](mongop, bson.M{
+		"deletion.scheduled_at": bson.M{"$lte": now},
+		"$or": bson.A{
+			bson.M{"deletion.purge_started_at": bson.M{"$exists": false}},
+			bson.M{"deletion.purge_started_at": bson.M{"$lte": now.Add(-purgeTimeout)}},
+		},
+	}, bson.M{"$set": bson.M{"deletion.purge_started_at": now}})
+}
+
+// purgeAccount reserves the identifiers of a claimed account and deletes
+// it together with everything it owns. The account document is removed
+// last so a failed purge is retried with all references intact.
+func (ac *AccountController) purgeAccount(account model.Account) error {
+	conf := ac.GlobalController.Config
+	id := account.ID.Hex()
+
+	expiresAt := time.Now().AddDate(0, 0, conf.Account.IdentifierCooldown)
+	if err := ac.reserveIdentifier(account.ID, model.ReservedUsername, account.Username, model.ReservedReasonDeleted, expiresAt); err != nil {
+		return err
+	}
+
+	if err := ac.reserveIdentifier(account.ID, model.ReservedEmail, account.Email.Value, model.ReservedReasonDeleted, expiresAt); err != nil {
+		return err
+	}
+
+	if err := ac.revokeAllSessions(id); err != nil {
+		return err
+	}
+
+	ac.deleteAvatarBlobs(account.Metadata.Profile.AvatarKeys)
+
+	exports, err := db.FindManyDocumentsByFilter[model.DataExport](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         conf.Mongo.DatabaseName,
+		CollectionName: exportCollectionName,
+	}, bson.M{"account_id": account.ID})
+	if err != nil {
+		return fmt.Errorf("failed to list exports: %w", err)
+	}
+
+	for _, export := range exports {
+		if err := ac.deleteExport(context.Background(), export); err != nil {
+			return err
+		}
+	}
+
+	for _, owned := range ownedCollections {
+		_, err := db.DeleteManyDocuments(db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         conf.Mongo.DatabaseName,
+			CollectionName: owned.name,
+		}, bson.M{owned.field: account.ID})
+		if err != nil {
+			return fmt.Errorf("failed to purge %s: %w", owned.name, err)
+		}
+	}
+
+	// Queued mail is matched by address since outbox messages do not
+	// reference the account.
+	if len(account.Email.Value) > 0 {
+		_, err := db.DeleteManyDocuments(ac.GlobalController.Mail.Params, bson.M{"to": account.Email.Value})
+		if err != nil {
+			return fmt.Errorf("failed to purge queued mail: %w", err)
+		}
+	}
+
+	_, err = db.DeleteDocument(db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         conf.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}, bson.M{"_id": account.ID})
+	if err != nil {
+		return fmt.Errorf("failed to delete account: %w", err)
+	}
+
+	return nil
+}
+
+// reserveIdentifier holds back a username or email address taken from
+// the provided account until the provided expiry. Reserving a value
+// again extends its reservation.
+func (ac *AccountController) reserveIdentifier(accountId primitive.ObjectID, kind, value, reason string, expiresAt time.Time) error {
+	if len(value) == 0 {
+		return nil
+	}
+
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: reservedCollectionName,
+	}
+
+	now := time.Now()
+	_, err := db.FindOneAndUpdateDocument[model.ReservedIdentifier](mongop,
+		bson.M{"kind": kind, "value": value},
+		bson.M{
+			"$set":         bson.M{"account_id": accountId, "reason": reason, "expires_at": expiresAt},
+			"$setOnInsert": bson.M{"created_at": now},
+		}, options.FindOneAndUpdate().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to reserve %s: %w", kind, err)
+	}
+
+	return nil
+}
+
+// identifierReserved reports whether a username or email address is held
+// back from reuse.
+func (ac *AccountController) identifierReserved(kind, value string) (bool, error) {
+	return ac.identifierReservedFor(primitive.NilObjectID, kind, value)
+}
+
+// identifierReservedFor reports whether a username or email address is
+// held back from the provided account. Values reserved from the account
+// itself remain available to it.
+func (ac *AccountController) identifierReservedFor(accountId primitive.ObjectID, kind, value string) (bool, error) {
+	filter := bson.M{"kind": kind, "value": value, "expires_at": bson.M{"$gt": time.Now()}}
+	if !accountId.IsZero() {
+		filter["account_id"] = bson.M{"$ne": accountId}
+	}
+
+	_, err := db.FindDocumentByFilter[model.ReservedIdentifier](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: reservedCollectionName,
+	}, filter)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to perform reservation lookup: %w", err)
+	}
+
+	return true, nil
+}
+
+// reauthenticate confirms the identity of the account owner before a
+// sensitive change using the account password and, if enabled, a second
+// factor. Accounts signing in only through passkeys or providers have no
+// password to prove and must have signed in within ReauthMaxAge instead.
+// Wrong passwords count towards the login lockout. On failure an error
+// response is written and false is returned.
+func (ac *AccountController) reauthenticate(ctx *gin.Context, account model.Account, req request.ReauthRequest) bool {
+	if len(account.Password) == 0 {
+		maxAge := time.Duration(ac.GlobalController.Config.Auth.ReauthMaxAge) * time.Minute
+		if maxAge <= 0 {
+			maxAge = 5 * time.Minute
+		}
+
+		if !util.AuthenticatedWithin(ctx.GetTime("authTime"), time.Now(), maxAge) {
+			util.CreateError(ctx, http.StatusUnauthorized, "recent sign-in required")
+			return false
+		}
+	} else if !ac.verifyReauthPassword(ctx, account, req.Password) {
+		return false
+	}
+
+	if account.MFA.Enabled && !ac.checkSecondFactor(ctx, account, req.MFACodeRequest) {
+		return false
+	}
+
+	return true
+}
+
+// verifyReauthPassword checks the password of the account, throttled
+// like a login so the check can not be used to guess the password. On
+// failure an error response is written and false is returned.
+func (ac *AccountController) verifyReauthPassword(ctx *gin.Context, account model.Account, password string) bool {
+	subjects := ac.loginSubjects(ctx, &account, account.Username)
+	if !ac.checkLoginLockout(ctx, subjects) {
+		return false
+	}
+
+	valid, _, err := ac.GlobalController.Passwords.Verify(password, account.Password)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to verify password: "+err.Error())
+		return false
+	}
+
+	if !valid {
+		if err := ac.recordLoginFailure(ctx, &account, subjects); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return false
+		}
+
+		if !ac.checkLoginLockout(ctx, subjects) {
+			return false
+		}
+
+		util.CreateError(ctx, http.StatusUnauthorized, "invalid password")
+		return false
+	}
+
+	if err := ac.resetLoginFailures(account); err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	return true
+}
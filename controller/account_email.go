@@ -0,0 +1,320 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/util"
+	"time"
+)
+
+// ChangeEmail starts changing the email address of the requesting
+// account. The new address is held as pending until it is confirmed
+// through a link sent to it, while the current address receives a link
+// to revert the change.
+func (ac *AccountController) ChangeEmail() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+		conf := ac.GlobalController.Config
+
+		var req request.ChangeEmailRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		if !util.ValidateEmail(req.Email) {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid email")
+			return
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		if req.Email == account.Email.Value {
+			util.CreateError(ctx, http.StatusBadRequest, "email is unchanged")
+			return
+		}
+
+		if !ac.reauthenticate(ctx, account, req.ReauthRequest) {
+			return
+		}
+
+		available, err := ac.emailAvailable(account.ID, req.Email)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if !available {
+			util.CreateError(ctx, http.StatusConflict, "email is in use")
+			return
+		}
+
+		id := account.ID.Hex()
+		cooldown := conf.Auth.ConfirmationCooldown * 60
+		ok, err = db.SetCacheValueIfAbsent(redisp, confirmationCooldownPrefix+id, 1, cooldown)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to apply confirmation cooldown: "+err.Error())
+			return
+		}
+
+		if !ok {
+			ctx.Header("Retry-After", fmt.Sprint(cooldown))
+			util.CreateError(ctx, http.StatusTooManyRequests, "confirmation was requested too recently")
+			return
+		}
+
+		_, err = db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+			"$set": bson.M{"pending_email": model.AccountPendingEmail{
+				Value:       req.Email,
+				RequestedAt: time.Now(),
+			}},
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to store pending email: "+err.Error())
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "pending_email", "", req.Email)
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		changeId, err := ac.storeConfirmation(model.Confirmation{
+			AccountID: id,
+			Field:     model.ConfirmEmailChange,
+			Value:     req.Email,
+		}, conf.Auth.ConfirmationTTL*60)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		revertId, err := ac.storeConfirmation(model.Confirmation{
+			AccountID: id,
+			Field:     model.ConfirmEmailRevert,
+			Value:     account.Email.Value,
+		}, conf.Auth.EmailRevertTTL*60)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		err = ac.GlobalController.Mail.Enqueue(req.Email, "email_change", map[string]string{
+			"Link": conf.Gin.PublicURL + "/v1/account/confirm/" + changeId,
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to send confirmation: "+err.Error())
+			return
+		}
+
+		err = ac.GlobalController.Mail.Enqueue(account.Email.Value, "email_change_notice", map[string]string{
+			"NewEmail": req.Email,
+			"Link":     conf.Gin.PublicURL + "/v1/account/confirm/" + revertId,
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to send change notice: "+err.Error())
+			return
+		}
+
+		ctx.Status(http.StatusAccepted)
+	}
+}
+
+// confirmEmailChange replaces the email address of an account with its
+// pending address. Availability is checked again since another account
+// may have taken the address after the change was requested.
+func (ac *AccountController) confirmEmailChange(ctx *gin.Context, key string, account model.Account, confirmation model.Confirmation) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	// A newer change request or a revert replaces the pending address,
+	// leaving this confirmation behind.
+	if account.PendingEmail == nil || account.PendingEmail.Value != confirmation.Value {
+		_, _ = db.DeleteCacheValue(redisp, key)
+		util.CreateError(ctx, http.StatusGone, "confirmation no longer matches account")
+		return
+	}
+
+	available, err := ac.emailAvailable(account.ID, confirmation.Value)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !available {
+		_, _ = db.DeleteCacheValue(redisp, key)
+		_, _ = db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{"$unset": bson.M{"pending_email": ""}})
+		util.CreateError(ctx, http.StatusConflict, "email is in use")
+		return
+	}
+
+	now := time.Now()
+	result, err := db.UpdateDocumentMatching(mongop, bson.M{
+		"_id":                 account.ID,
+		"pending_email.value": confirmation.Value,
+	}, bson.M{
+		"$set": bson.M{"email": model.AccountConfirmable{
+			Value:       confirmation.Value,
+			Confirmed:   true,
+			ConfirmedAt: now,
+		}},
+		"$unset": bson.M{"pending_email": ""},
+	})
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to change email: "+err.Error())
+		return
+	}
+
+	if result.ModifiedCount == 0 {
+		_, _ = db.DeleteCacheValue(redisp, key)
+		util.CreateError(ctx, http.StatusGone, "confirmation no longer matches account")
+		return
+	}
+
+	// The previous address is held back for as long as the change can be
+	// reverted so the revert can not fail because it was taken.
+	expiresAt := now.Add(time.Duration(ac.GlobalController.Config.Auth.EmailRevertTTL) * time.Minute)
+	if err := ac.reserveIdentifier(account.ID, model.ReservedEmail, account.Email.Value, model.ReservedReasonEmailChanged, expiresAt); err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	entry := ac.GlobalController.newAuditEntry(ctx, account, "email", account.Email.Value, confirmation.Value)
+	entry.ActorID = account.ID
+	if err := ac.GlobalController.recordAudit(entry); err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+		return
+	}
+
+	_, err = db.DeleteCacheValue(redisp, key)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to remove confirmation: "+err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// revertEmailChange restores the previous email address of an account.
+// A change that was not confirmed yet is cancelled, while a completed
+// change is undone and the account is signed out everywhere in case the
+// change was not made by its owner.
+func (ac *AccountController) revertEmailChange(ctx *gin.Context, key string, account model.Account, confirmation model.Confirmation) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	if account.Email.Value == confirmation.Value {
+		_, err := db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{"$unset": bson.M{"pending_email": ""}})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to cancel email change: "+err.Error())
+			return
+		}
+
+		if account.PendingEmail != nil {
+			entry := ac.GlobalController.newAuditEntry(ctx, account, "pending_email", account.PendingEmail.Value, "")
+			entry.ActorID = account.ID
+			if err := ac.GlobalController.recordAudit(entry); err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+				return
+			}
+		}
+
+		_, _ = db.DeleteCacheValue(redisp, key)
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	available, err := ac.emailAvailable(account.ID, confirmation.Value)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if !available {
+		util.CreateError(ctx, http.StatusConflict, "email is in use")
+		return
+	}
+
+	_, err = db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+		"$set": bson.M{"email": model.AccountConfirmable{
+			Value:       confirmation.Value,
+			Confirmed:   true,
+			ConfirmedAt: time.Now(),
+		}},
+		"$unset": bson.M{"pending_email": ""},
+	})
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to revert email: "+err.Error())
+		return
+	}
+
+	entry := ac.GlobalController.newAuditEntry(ctx, account, "email", account.Email.Value, confirmation.Value)
+	entry.ActorID = account.ID
+	if err := ac.GlobalController.recordAudit(entry); err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+		return
+	}
+
+	if err := ac.revokeAllSessions(account.ID.Hex()); err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_, err = db.DeleteCacheValue(redisp, key)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to remove confirmation: "+err.Error())
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// emailAvailable reports whether the provided account may use an email
+// address, which must neither belong to another account nor be held
+// back from it.
+func (ac *AccountController) emailAvailable(accountId primitive.ObjectID, email string) (bool, error) {
+	_, err := db.FindDocumentByFilter[model.Account](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}, bson.M{"email.value": email, "_id": bson.M{"$ne": accountId}})
+	if err == nil {
+		return false, nil
+	}
+
+	if err != mongo.ErrNoDocuments {
+		return false, fmt.Errorf("failed to perform duplicate email lookup: %w", err)
+	}
+
+	reserved, err := ac.identifierReservedFor(accountId, model.ReservedEmail, email)
+	if err != nil {
+		return false, err
+	}
+
+	return !reserved, nil
+}
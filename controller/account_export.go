@@ -0,0 +1,501 @@
+package controller
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/response"
+	"tc-server/storage"
+	"tc-server/util"
+	"time"
+)
+
+const (
+	exportCollectionName = "data_export"
+
+	// exportTimeout is how long a claimed export may be processed before
+	// it is claimed again, and maxExportAttempts how often an export is
+	// attempted before it is marked as failed.
+	exportTimeout     = 10 * time.Minute
+	maxExportAttempts = 3
+)
+
+// RequestExport queues an export of all data stored about the requesting
+// account. The archive is built in the background and a download link is
+// mailed once it is ready.
+func (ac *AccountController) RequestExport() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: exportCollectionName,
+		}
+
+		accountId, err := primitive.ObjectIDFromHex(ctx.GetString("accountId"))
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "invalid account id: "+err.Error())
+			return
+		}
+
+		_, err = db.FindDocumentByFilter[model.DataExport](mongop, bson.M{
+			"account_id": accountId,
+			"status":     bson.M{"$in": bson.A{model.ExportStatusPending, model.ExportStatusProcessing}},
+		})
+		if err == nil {
+			util.CreateError(ctx, http.StatusConflict, "an export is already in progress")
+			return
+		}
+
+		if err != mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform export lookup: "+err.Error())
+			return
+		}
+
+		export := model.DataExport{
+			AccountID:   accountId,
+			Status:      model.ExportStatusPending,
+			RequestedAt: time.Now(),
+		}
+
+		id, err := db.InsertDocument(mongop, export)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to insert export document: "+err.Error())
+			return
+		}
+
+		export.ID, _ = primitive.ObjectIDFromHex(id)
+		ctx.JSON(http.StatusAccepted, response.NewDataExportResponse(export, ""))
+	}
+}
+
+// ListExports returns the data exports of the requesting account, most
+// recent first. Exports that are ready carry a fresh download link.
+func (ac *AccountController) ListExports() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		accountId, err := primitive.ObjectIDFromHex(ctx.GetString("accountId"))
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "invalid account id: "+err.Error())
+			return
+		}
+
+		exports, err := db.FindManyDocumentsByFilterWithOpts[model.DataExport](db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: exportCollectionName,
+		}, bson.M{"account_id": accountId}, options.Find().SetSort(bson.M{"requested_at": -1}))
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to list exports: "+err.Error())
+			return
+		}
+
+		res := make([]response.DataExportResponse, 0, len(exports))
+		for _, export := range exports {
+			res = append(res, response.NewDataExportResponse(export, ac.exportDownloadURL(export)))
+		}
+
+		ctx.JSON(http.StatusOK, res)
+	}
+}
+
+// DownloadExport streams an export archive to anyone holding a valid
+// signed download link.
+func (ac *AccountController) DownloadExport() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		exportId := ctx.Param("exportId")
+
+		unix, err := strconv.ParseInt(ctx.Query("expires"), 10, 64)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid download link")
+			return
+		}
+
+		expires := time.Unix(unix, 0)
+		if !util.VerifyExpiring(ac.GlobalController.Config.Export.SigningKey, exportId, expires, ctx.Query("signature")) {
+			util.CreateError(ctx, http.StatusForbidden, "invalid or expired download link")
+			return
+		}
+
+		export, err := db.FindDocumentById[model.DataExport](db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: exportCollectionName,
+		}, exportId)
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusNotFound, "export not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform export lookup: "+err.Error())
+			return
+		}
+
+		if export.Status != model.ExportStatusReady || time.Now().After(export.ExpiresAt) {
+			util.CreateError(ctx, http.StatusNotFound, "export not found")
+			return
+		}
+
+		blob, err := ac.GlobalController.Exports.Get(ctx.Request.Context(), export.BlobKey)
+		if err == storage.ErrNotFound {
+			util.CreateError(ctx, http.StatusNotFound, "export not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to open export: "+err.Error())
+			return
+		}
+		defer blob.Close()
+
+		filename := "training-club-export-" + export.CompletedAt.Format("2006-01-02") + ".zip"
+		ctx.DataFromReader(http.StatusOK, export.Size, "application/zip", blob, map[string]string{
+			"Content-Disposition": `attachment; filename="` + filename + `"`,
+			"Cache-Control":       "no-store",
+		})
+	}
+}
+
+// RunDataExports builds queued data exports and removes expired archives
+// until the context is cancelled.
+func (c *GlobalController) RunDataExports(ctx context.Context) {
+	ac := AccountController{
+		GlobalController: c,
+		CollectionName:   "account",
+	}
+
+	interval := time.Duration(c.Config.Export.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ac.processExports(ctx)
+		ac.expireExports(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processExports builds exports until none are due.
+func (ac *AccountController) processExports(ctx context.Context) {
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: exportCollectionName,
+	}
+
+	for {
+		export, err := ac.claimExport()
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+
+		if err != nil {
+			fmt.Println("Failed to claim data export: " + err.Error())
+			return
+		}
+
+		if err := ac.buildExport(ctx, export); err != nil {
+			fmt.Println("Failed to build data export " + export.ID.Hex() + ": " + err.Error())
+
+			status := model.ExportStatusPending
+			if export.Attempts >= maxExportAttempts {
+				status = model.ExportStatusFailed
+			}
+
+			_, err := db.UpdateDocumentByFilter[model.DataExport](mongop, export.ID, bson.M{
+				"$set": bson.M{"status": status, "last_error": err.Error()},
+			})
+			if err != nil {
+				fmt.Println("Failed to update data export: " + err.Error())
+			}
+		}
+	}
+}
+
+// claimExport marks the next pending export as processing. Exports that
+// were not finished within exportTimeout are claimed again.
+func (ac *AccountController) claimExport() (model.DataExport, error) {
+	now := time.Now()
+
+	return db.FindOneAndUpdateDocument[model.DataExport](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: exportCollectionName,
+	}, bson.M{
+		"$or": bson.A{
+			bson.M{"status": model.ExportStatusPending},
+			bson.M{"status": model.ExportStatusProcessing, "locked_until": bson.M{"$lte": now}},
+		},
+	}, bson.M{
+		"$set": bson.M{"status": model.ExportStatusProcessing, "locked_until": now.Add(exportTimeout)},
+		"$inc": bson.M{"attempts": 1},
+	}, options.FindOneAndUpdate().SetSort(bson.M{"requested_at": 1}))
+}
+
+// buildExport collects the data of the export account into an archive,
+// stores it and mails the download link to the account owner.
+func (ac *AccountController) buildExport(ctx context.Context, export model.DataExport) error {
+	conf := ac.GlobalController.Config
+
+	account, err := db.FindDocumentById[model.Account](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         conf.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}, export.AccountID.Hex())
+	if err != nil {
+		return fmt.Errorf("failed to perform account lookup: %w", err)
+	}
+
+	archive, err := ac.exportArchive(account)
+	if err != nil {
+		return err
+	}
+
+	key, err := util.GenerateRandomString(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate export key: %w", err)
+	}
+
+	// Archives live in the private export store and are only handed out
+	// through the signed download route.
+	key = "exports/" + key + ".zip"
+	if err := ac.GlobalController.Exports.Put(ctx, key, bytes.NewReader(archive), int64(len(archive)), "application/zip"); err != nil {
+		return fmt.Errorf("failed to store export: %w", err)
+	}
+
+	now := time.Now()
+	export.Status = model.ExportStatusReady
+	export.BlobKey = key
+	export.Size = int64(len(archive))
+	export.CompletedAt = now
+	export.ExpiresAt = now.Add(time.Duration(conf.Export.ArchiveTTL) * time.Hour)
+
+	_, err = db.UpdateDocumentByFilter[model.DataExport](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         conf.Mongo.DatabaseName,
+		CollectionName: exportCollectionName,
+	}, export.ID, bson.M{
+		"$set": bson.M{
+			"status":       export.Status,
+			"blob_key":     export.BlobKey,
+			"size":         export.Size,
+			"completed_at": export.CompletedAt,
+			"expires_at":   export.ExpiresAt,
+		},
+		"$unset": bson.M{"locked_until": "", "last_error": ""},
+	})
+	if err != nil {
+		_ = ac.GlobalController.Exports.Delete(ctx, key)
+		return fmt.Errorf("failed to update export: %w", err)
+	}
+
+	link, expires := ac.exportDownloadLink(export)
+	if err := ac.GlobalController.Mail.Enqueue(account.Email.Value, "data_export_ready", map[string]any{
+		"Link":    link,
+		"Expires": expires.UTC().Format("January 2, 2006 15:04 MST"),
+	}); err != nil {
+		fmt.Println("Failed to send data export notice: " + err.Error())
+	}
+
+	return nil
+}
+
+// exportArchive builds a ZIP archive holding the account, its sessions
+// and every document of the owned collections as JSON files together
+// with a human readable summary.
+func (ac *AccountController) exportArchive(account model.Account) ([]byte, error) {
+	conf := ac.GlobalController.Config
+
+	sessions, err := ac.accountSessions(account.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	sessionResponses := make([]response.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		sessionResponses = append(sessionResponses, response.NewSessionResponse(session, false))
+	}
+
+	passkeys := account.Passkeys
+	if passkeys == nil {
+		passkeys = []model.Passkey{}
+	}
+
+	files := []struct {
+		name string
+		data any
+	}{
+		{"account.json", response.NewAccountResponse(account)},
+		{"passkeys.json", passkeys},
+		{"sessions.json", sessionResponses},
+	}
+
+	counts := map[string]int{}
+	for _, owned := range ownedCollections {
+		documents, err := db.FindManyDocumentsByFilter[bson.M](db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         conf.Mongo.DatabaseName,
+			CollectionName: owned.name,
+		}, bson.M{owned.field: account.ID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", owned.name, err)
+		}
+
+		if documents == nil {
+			documents = []bson.M{}
+		}
+
+		counts[owned.name] = len(documents)
+		files = append(files, struct {
+			name string
+			data any
+		}{owned.name + ".json", documents})
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	summary, err := archive.Create("README.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := summary.Write([]byte(exportSummary(account, len(passkeys), len(sessions), counts))); err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", file.name, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// exportSummary describes the contents of an export archive in plain
+// text.
+func exportSummary(account model.Account, passkeys int, sessions int, counts map[string]int) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Training Club data export\n")
+	fmt.Fprintf(&b, "Generated: %s\n\n", time.Now().UTC().Format(time.RFC1123))
+	fmt.Fprintf(&b, "Username: %s\n", account.Username)
+	fmt.Fprintf(&b, "Email: %s (confirmed: %t)\n", account.Email.Value, account.Email.Confirmed)
+	fmt.Fprintf(&b, "Roles: %s\n", strings.Join(account.EffectiveRoles(), ", "))
+	fmt.Fprintf(&b, "Created: %s\n", account.Metadata.CreatedAt.UTC().Format(time.RFC1123))
+	fmt.Fprintf(&b, "Two-factor authentication: %t\n", account.MFA.Enabled)
+	fmt.Fprintf(&b, "Linked providers: %d\n\n", len(account.Identities))
+
+	fmt.Fprintf(&b, "Files\n")
+	fmt.Fprintf(&b, "  account.json: your account and profile\n")
+	fmt.Fprintf(&b, "  passkeys.json: %d registered passkeys, public keys are omitted\n", passkeys)
+	fmt.Fprintf(&b, "  sessions.json: %d active sessions\n", sessions)
+	for _, owned := range ownedCollections {
+		fmt.Fprintf(&b, "  %s.json: %d records\n", owned.name, counts[owned.name])
+	}
+
+	fmt.Fprintf(&b, "\nPasswords, two-factor secrets and recovery codes are stored hashed or encrypted and are not part of this export.\n")
+	return b.String()
+}
+
+// exportDownloadLink returns a signed download link for a ready export
+// and the time it expires, which is never after the archive expires.
+func (ac *AccountController) exportDownloadLink(export model.DataExport) (string, time.Time) {
+	conf := ac.GlobalController.Config
+
+	expires := time.Now().Add(time.Duration(conf.Export.LinkTTL) * time.Minute)
+	if export.ExpiresAt.Before(expires) {
+		expires = export.ExpiresAt
+	}
+
+	expires = expires.Truncate(time.Second)
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", util.SignExpiring(conf.Export.SigningKey, export.ID.Hex(), expires))
+
+	return conf.Gin.PublicURL + "/v1/account/exports/" + export.ID.Hex() + "/download?" + query.Encode(), expires
+}
+
+// exportDownloadURL returns a signed download link if the export archive
+// is available, otherwise an empty string.
+func (ac *AccountController) exportDownloadURL(export model.DataExport) string {
+	if export.Status != model.ExportStatusReady || time.Now().After(export.ExpiresAt) {
+		return ""
+	}
+
+	link, _ := ac.exportDownloadLink(export)
+	return link
+}
+
+// expireExports removes archives that are past their expiry.
+func (ac *AccountController) expireExports(ctx context.Context) {
+	exports, err := db.FindManyDocumentsByFilter[model.DataExport](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: exportCollectionName,
+	}, bson.M{"status": model.ExportStatusReady, "expires_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		fmt.Println("Failed to list expired data exports: " + err.Error())
+		return
+	}
+
+	for _, export := range exports {
+		if err := ac.deleteExport(ctx, export); err != nil {
+			fmt.Println("Failed to remove data export " + export.ID.Hex() + ": " + err.Error())
+		}
+	}
+}
+
+// deleteExport removes the archive of an export together with the
+// export document.
+func (ac *AccountController) deleteExport(ctx context.Context, export model.DataExport) error {
+	if len(export.BlobKey) > 0 {
+		if err := ac.GlobalController.Exports.Delete(ctx, export.BlobKey); err != nil {
+			return fmt.Errorf("failed to delete archive: %w", err)
+		}
+	}
+
+	_, err := db.DeleteDocument(db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: exportCollectionName,
+	}, bson.M{"_id": export.ID})
+	if err != nil {
+		return fmt.Errorf("failed to delete export: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,479 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"slices"
+	"strings"
+	"tc-server/db"
+	"tc-server/middleware"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/response"
+	"tc-server/util"
+	"time"
+)
+
+const (
+	// mfaAttemptsPrefix prefixes the cache key counting second factor
+	// attempts made with a single MFA token.
+	mfaAttemptsPrefix = "mfa_attempts:"
+
+	// totpUsedPrefix prefixes the cache key marking a TOTP time step as
+	// used, so a code can not be replayed within its validity window.
+	totpUsedPrefix = "totp_used:"
+
+	// maxMFAAttempts is the number of second factors that may be tried
+	// with a single MFA token before it is revoked.
+	maxMFAAttempts = 5
+
+	// recoveryCodeCount is the number of recovery codes generated when a
+	// second factor is enabled.
+	recoveryCodeCount = 10
+)
+
+// LoginMFA completes a login step-up by exchanging an MFA token issued by
+// Login together with a TOTP code or a recovery code for a token pair.
+// Each MFA token can only be exchanged once.
+func (ac *AccountController) LoginMFA() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		var req request.MFALoginRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		claims, err := util.ValidateTokenClaims(req.MFAToken, ac.GlobalController.MFAKey)
+		if err != nil || !slices.Contains(claims.Scopes, model.ScopeMFAPending) {
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid mfa token")
+			return
+		}
+
+		revoked, err := middleware.IsTokenRevoked(redisp, claims.ID)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to query token revocation: "+err.Error())
+			return
+		}
+
+		if revoked {
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid mfa token")
+			return
+		}
+
+		ttl := int(time.Until(claims.ExpiresAt.Time).Seconds()) + 1
+		attempts, err := db.IncrementCacheCounter(redisp, mfaAttemptsPrefix+claims.ID, ttl)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to count mfa attempts: "+err.Error())
+			return
+		}
+
+		if attempts > maxMFAAttempts {
+			if err := middleware.RevokeToken(redisp, claims.ID, ttl); err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, "failed to revoke mfa token: "+err.Error())
+				return
+			}
+
+			util.CreateError(ctx, http.StatusUnauthorized, "too many attempts, please log in again")
+			return
+		}
+
+		account, err := db.FindDocumentById[model.Account](mongop, claims.AccountID)
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid mfa token")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		if !ac.checkSecondFactor(ctx, account, req.MFACodeRequest) {
+			return
+		}
+
+		if err := middleware.RevokeToken(redisp, claims.ID, ttl); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to revoke mfa token: "+err.Error())
+			return
+		}
+
+		id := account.ID.Hex()
+		accesstoken, refreshtoken, err := ac.issueTokens(ctx, id, account.EffectiveRoles(), "")
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.AccountLoginResponse{
+			ID:           id,
+			AccessToken:  accesstoken,
+			RefreshToken: refreshtoken,
+		})
+	}
+}
+
+// EnrollTOTP generates a new TOTP secret for the requesting account. The
+// secret stays pending until a code generated from it is verified.
+func (ac *AccountController) EnrollTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		if account.MFA.Enabled {
+			util.CreateError(ctx, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+
+		secret, err := util.GenerateTOTPSecret()
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate secret: "+err.Error())
+			return
+		}
+
+		sealed, err := ac.GlobalController.Secrets.Seal(secret)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to encrypt secret: "+err.Error())
+			return
+		}
+
+		_, err = db.UpdateDocument(mongop, account.ID, "mfa.pending_secret", sealed)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to store secret: "+err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.TOTPEnrollResponse{
+			Secret: secret,
+			URI:    util.TOTPURI(ac.GlobalController.Config.Auth.MFAIssuer, account.Username, secret),
+		})
+	}
+}
+
+// VerifyTOTP activates the pending TOTP secret of the requesting account
+// if the provided code matches it. The recovery codes are only ever
+// returned by this request.
+func (ac *AccountController) VerifyTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		var req request.MFACodeRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		if account.MFA.Enabled {
+			util.CreateError(ctx, http.StatusConflict, "two-factor authentication is already enabled")
+			return
+		}
+
+		if len(account.MFA.PendingSecret) == 0 {
+			util.CreateError(ctx, http.StatusBadRequest, "no pending two-factor enrollment")
+			return
+		}
+
+		secret, err := ac.GlobalController.Secrets.Open(account.MFA.PendingSecret)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to decrypt secret: "+err.Error())
+			return
+		}
+
+		if _, ok := util.ValidateTOTP(secret, req.Code, time.Now()); !ok {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid code")
+			return
+		}
+
+		codes, hashes, err := generateRecoveryCodes()
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		_, err = db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+			"$set": bson.M{
+				"mfa.enabled":        true,
+				"mfa.secret":         account.MFA.PendingSecret,
+				"mfa.recovery_codes": hashes,
+				"mfa.enabled_at":     time.Now(),
+			},
+			"$unset": bson.M{"mfa.pending_secret": ""},
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to enable two-factor authentication: "+err.Error())
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "mfa.enabled", "false", "true")
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the requesting
+// account after verifying a second factor.
+func (ac *AccountController) RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		account, req, ok := ac.requireSecondFactor(ctx)
+		if !ok {
+			return
+		}
+
+		codes, hashes, err := generateRecoveryCodes()
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		_, err = db.UpdateDocument(mongop, account.ID, "mfa.recovery_codes", hashes)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to store recovery codes: "+err.Error())
+			return
+		}
+
+		used := "code"
+		if len(req.Code) == 0 {
+			used = "recovery_code"
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "mfa.recovery_codes", used, "regenerated")
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.RecoveryCodesResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableTOTP removes the second factor of the requesting account after
+// verifying it one last time.
+func (ac *AccountController) DisableTOTP() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		account, _, ok := ac.requireSecondFactor(ctx)
+		if !ok {
+			return
+		}
+
+		_, err := db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+			"$unset": bson.M{"mfa": ""},
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to disable two-factor authentication: "+err.Error())
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "mfa.enabled", "true", "false")
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// requestingAccount looks up the account matching the request token. If
+// the lookup fails an error response is written and false is returned.
+func (ac *AccountController) requestingAccount(ctx *gin.Context) (model.Account, bool) {
+	account, err := db.FindDocumentById[model.Account](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}, ctx.GetString("accountId"))
+	if err == mongo.ErrNoDocuments {
+		util.CreateError(ctx, http.StatusNotFound, "account not found")
+		return account, false
+	}
+
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+		return account, false
+	}
+
+	return account, true
+}
+
+// requireSecondFactor binds a second factor from the request body and
+// verifies it against the requesting account, which must have two-factor
+// authentication enabled. On failure an error response is written and
+// false is returned.
+func (ac *AccountController) requireSecondFactor(ctx *gin.Context) (model.Account, request.MFACodeRequest, bool) {
+	var req request.MFACodeRequest
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+		return model.Account{}, req, false
+	}
+
+	account, ok := ac.requestingAccount(ctx)
+	if !ok {
+		return account, req, false
+	}
+
+	if !account.MFA.Enabled {
+		util.CreateError(ctx, http.StatusConflict, "two-factor authentication is not enabled")
+		return account, req, false
+	}
+
+	return account, req, ac.checkSecondFactor(ctx, account, req)
+}
+
+// checkSecondFactor verifies a second factor of the account, counting
+// failures towards the login lockout of its second factor. The lockout
+// outlives the MFA token, so codes can not be guessed by logging in with
+// the password again for a fresh token. On failure an error response is
+// written and false is returned.
+func (ac *AccountController) checkSecondFactor(ctx *gin.Context, account model.Account, req request.MFACodeRequest) bool {
+	subjects := ac.secondFactorSubjects(ctx, account)
+	if !ac.checkLoginLockout(ctx, subjects) {
+		return false
+	}
+
+	ok, err := ac.verifySecondFactor(account, req)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	if !ok {
+		if err := ac.recordLoginFailure(ctx, &account, subjects); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return false
+		}
+
+		if !ac.checkLoginLockout(ctx, subjects) {
+			return false
+		}
+
+		util.CreateError(ctx, http.StatusUnauthorized, "invalid code")
+		return false
+	}
+
+	if err := ac.resetSecondFactorFailures(account); err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return false
+	}
+
+	return true
+}
+
+// verifySecondFactor reports whether the TOTP code or recovery code of
+// the request is valid for the account. TOTP codes can not be replayed
+// and recovery codes are consumed on use.
+func (ac *AccountController) verifySecondFactor(account model.Account, req request.MFACodeRequest) (bool, error) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	if len(req.Code) > 0 {
+		secret, err := ac.GlobalController.Secrets.Open(account.MFA.Secret)
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt secret: %w", err)
+		}
+
+		step, ok := util.ValidateTOTP(secret, req.Code, time.Now())
+		if !ok {
+			return false, nil
+		}
+
+		// Codes are accepted for up to three time steps of 30 seconds.
+		key := fmt.Sprintf("%s%s:%d", totpUsedPrefix, account.ID.Hex(), step)
+		fresh, err := db.SetCacheValueIfAbsent(redisp, key, 1, 90)
+		if err != nil {
+			return false, fmt.Errorf("failed to mark code as used: %w", err)
+		}
+
+		return fresh, nil
+	}
+
+	if len(req.RecoveryCode) > 0 {
+		// Pulling the hash is atomic, so a recovery code can only ever be
+		// consumed by a single request.
+		result, err := db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+			"$pull": bson.M{"mfa.recovery_codes": hashRecoveryCode(req.RecoveryCode)},
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to consume recovery code: %w", err)
+		}
+
+		return result.ModifiedCount == 1, nil
+	}
+
+	return false, nil
+}
+
+// generateRecoveryCodes returns a new set of recovery codes alongside
+// the hashes they are stored as.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := util.GenerateRandomString(5)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case and separators.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,514 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"strings"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/oidc"
+	"tc-server/request"
+	"tc-server/response"
+	"tc-server/util"
+	"time"
+)
+
+// oidcStatePrefix prefixes the cache key of a pending OpenID Connect
+// authorization.
+const oidcStatePrefix = "oidc_state:"
+
+// AuthorizeOIDC starts a sign-in with an OpenID Connect provider and
+// returns the provider authorization URL the client redirects to.
+func (ac *AccountController) AuthorizeOIDC() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ac.authorizeOIDC(ctx, "")
+	}
+}
+
+// LinkOIDC starts linking an OpenID Connect provider to the requesting
+// account and returns the provider authorization URL the client
+// redirects to. Linking adds a way of signing in, so the account owner
+// has to reauthenticate first. The link is completed by
+// LinkOIDCCallback.
+func (ac *AccountController) LinkOIDC() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req request.ReauthRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		if !ac.reauthenticate(ctx, account, req) {
+			return
+		}
+
+		ac.authorizeOIDC(ctx, account.ID.Hex())
+	}
+}
+
+// LinkOIDCCallback completes a link started by LinkOIDC using the code
+// and state the provider redirected back with. The link is only completed
+// for the account which started it, so an authorization URL passed on to
+// someone else can not link their provider account.
+func (ac *AccountController) LinkOIDCCallback() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		provider, req, state, ok := ac.consumeOIDCState(ctx)
+		if !ok {
+			return
+		}
+
+		if len(state.AccountID) == 0 || state.AccountID != ctx.GetString("accountId") {
+			util.CreateError(ctx, http.StatusForbidden, "state does not belong to account")
+			return
+		}
+
+		claims, err := provider.Exchange(ctx.Request.Context(), req.Code, state.Verifier, state.Nonce)
+		if err != nil {
+			util.CreateError(ctx, http.StatusUnauthorized, "failed to verify provider sign-in: "+err.Error())
+			return
+		}
+
+		linked, found, err := ac.linkedAccount(provider, claims)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ac.linkIdentity(ctx, state.AccountID, provider, claims, found, linked)
+	}
+}
+
+// OIDCCallback completes a sign-in started by AuthorizeOIDC using the
+// code and state the provider redirected back with.
+//
+// A linked provider account signs in to its account. Otherwise a new
+// account is created, unless an account already owns the provider email
+// address, in which case the provider has to be linked explicitly after
+// signing in to that account.
+func (ac *AccountController) OIDCCallback() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		provider, req, state, ok := ac.consumeOIDCState(ctx)
+		if !ok {
+			return
+		}
+
+		if len(state.AccountID) > 0 {
+			util.CreateError(ctx, http.StatusBadRequest, "state belongs to a provider link")
+			return
+		}
+
+		claims, err := provider.Exchange(ctx.Request.Context(), req.Code, state.Verifier, state.Nonce)
+		if err != nil {
+			util.CreateError(ctx, http.StatusUnauthorized, "failed to verify provider sign-in: "+err.Error())
+			return
+		}
+
+		linked, found, err := ac.linkedAccount(provider, claims)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if found {
+			ac.completeLogin(ctx, linked)
+			return
+		}
+
+		if len(claims.Email) == 0 || !util.ValidateEmail(claims.Email) {
+			util.CreateError(ctx, http.StatusBadRequest, "provider did not share a valid email address")
+			return
+		}
+
+		_, err = db.FindDocumentByKeyValue[string, model.Account](mongop, "email.value", claims.Email)
+		if err == nil {
+			util.CreateError(ctx, http.StatusConflict, "an account with this email already exists, sign in to link the provider")
+			return
+		}
+
+		if err != mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		reserved, err := ac.identifierReserved(model.ReservedEmail, claims.Email)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if reserved {
+			util.CreateError(ctx, http.StatusConflict, "email is in use")
+			return
+		}
+
+		ac.createOIDCAccount(ctx, provider, claims)
+	}
+}
+
+// UnlinkOIDC removes a linked provider from the requesting account. The
+// last way of signing in to an account can not be removed.
+func (ac *AccountController) UnlinkOIDC() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		name := ctx.Param("provider")
+		var identity *model.AccountIdentity
+		for i := range account.Identities {
+			if account.Identities[i].Provider == name {
+				identity = &account.Identities[i]
+			}
+		}
+
+		if identity == nil {
+			util.CreateError(ctx, http.StatusNotFound, "provider is not linked")
+			return
+		}
+
+		if len(account.Password) == 0 && len(account.Passkeys) == 0 && len(account.Identities) == 1 {
+			util.CreateError(ctx, http.StatusConflict, "set a password or add a passkey before unlinking the last provider")
+			return
+		}
+
+		_, err := db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+			"$pull": bson.M{"identities": bson.M{"provider": name}},
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to unlink provider: "+err.Error())
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "identities", name+":"+identity.Email, "")
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// consumeOIDCState binds the callback request and removes the pending
+// authorization it references, which must belong to the provider of the
+// request. On failure an error response is written and false is
+// returned.
+func (ac *AccountController) consumeOIDCState(ctx *gin.Context) (*oidc.Provider, request.OIDCCallbackRequest, model.OIDCState, bool) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	var req request.OIDCCallbackRequest
+	var state model.OIDCState
+
+	provider, ok := ac.GlobalController.OIDC[ctx.Param("provider")]
+	if !ok {
+		util.CreateError(ctx, http.StatusNotFound, "unknown provider")
+		return nil, req, state, false
+	}
+
+	err := ctx.ShouldBindJSON(&req)
+	if err != nil {
+		util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+		return nil, req, state, false
+	}
+
+	raw, err := db.GetAndDeleteCacheValue(redisp, oidcStatePrefix+req.State)
+	if err == redis.Nil {
+		util.CreateError(ctx, http.StatusBadRequest, "state not found or expired")
+		return nil, req, state, false
+	}
+
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to perform state lookup: "+err.Error())
+		return nil, req, state, false
+	}
+
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to parse state: "+err.Error())
+		return nil, req, state, false
+	}
+
+	if state.Provider != provider.Name {
+		util.CreateError(ctx, http.StatusBadRequest, "state does not belong to provider")
+		return nil, req, state, false
+	}
+
+	return provider, req, state, true
+}
+
+// linkedAccount returns the account the provider subject of the claims
+// is linked to, if any.
+func (ac *AccountController) linkedAccount(provider *oidc.Provider, claims *oidc.Claims) (model.Account, bool, error) {
+	linked, err := db.FindDocumentByFilter[model.Account](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider.Name, "subject": claims.Subject}},
+	})
+	if err == mongo.ErrNoDocuments {
+		return linked, false, nil
+	}
+
+	if err != nil {
+		return linked, false, fmt.Errorf("failed to perform identity lookup: %w", err)
+	}
+
+	return linked, true, nil
+}
+
+// authorizeOIDC stores a new authorization state for the provider of the
+// request and responds with the provider authorization URL.
+func (ac *AccountController) authorizeOIDC(ctx *gin.Context, accountId string) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	provider, ok := ac.GlobalController.OIDC[ctx.Param("provider")]
+	if !ok {
+		util.CreateError(ctx, http.StatusNotFound, "unknown provider")
+		return
+	}
+
+	state, err1 := oidc.NewState()
+	nonce, err2 := oidc.NewState()
+	verifier, err3 := oidc.NewVerifier()
+	if err1 != nil || err2 != nil || err3 != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to generate authorization state")
+		return
+	}
+
+	raw, err := json.Marshal(model.OIDCState{
+		Provider:  provider.Name,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		AccountID: accountId,
+	})
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to encode state: "+err.Error())
+		return
+	}
+
+	url, err := provider.AuthCodeURL(ctx.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		util.CreateError(ctx, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	_, err = db.SetCacheValue(redisp, oidcStatePrefix+state, string(raw), ac.GlobalController.Config.OIDC.StateTTL*60)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to cache state: "+err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.OIDCAuthorizeResponse{URL: url})
+}
+
+// linkIdentity links the provider subject of the claims to the account
+// which started the authorization. A subject may only be linked to a
+// single account and an account may only link a provider once.
+func (ac *AccountController) linkIdentity(ctx *gin.Context, accountId string, provider *oidc.Provider, claims *oidc.Claims, found bool, linked model.Account) {
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	if found && linked.ID.Hex() != accountId {
+		util.CreateError(ctx, http.StatusConflict, "provider account is linked to another account")
+		return
+	}
+
+	account, err := db.FindDocumentById[model.Account](mongop, accountId)
+	if err == mongo.ErrNoDocuments {
+		util.CreateError(ctx, http.StatusNotFound, "account not found")
+		return
+	}
+
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+		return
+	}
+
+	if found {
+		ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
+		return
+	}
+
+	for _, identity := range account.Identities {
+		if identity.Provider == provider.Name {
+			util.CreateError(ctx, http.StatusConflict, "another "+provider.Name+" account is already linked")
+			return
+		}
+	}
+
+	identity := model.AccountIdentity{
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		LinkedAt: time.Now(),
+	}
+
+	_, err = db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+		"$push": bson.M{"identities": identity},
+	})
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to link provider: "+err.Error())
+		return
+	}
+
+	entry := ac.GlobalController.newAuditEntry(ctx, account, "identities", "", provider.Name+":"+claims.Email)
+	if err := ac.GlobalController.recordAudit(entry); err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+		return
+	}
+
+	account.Identities = append(account.Identities, identity)
+	ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
+}
+
+// createOIDCAccount creates a new account without a password for the
+// provider account of the claims and responds with a new token pair.
+func (ac *AccountController) createOIDCAccount(ctx *gin.Context, provider *oidc.Provider, claims *oidc.Claims) {
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	username, err := ac.generateUsername(claims.PreferredUsername, claims.Email)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := time.Now()
+	insert := model.Account{
+		Username: username,
+		Email: model.AccountConfirmable{
+			Value:     claims.Email,
+			Confirmed: bool(claims.EmailVerified),
+		},
+		Roles: []string{model.RoleMember},
+		Identities: []model.AccountIdentity{{
+			Provider: provider.Name,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+			LinkedAt: now,
+		}},
+		Metadata: model.AccountMetadata{
+			CreatedAt: now,
+			LastSeen:  now,
+		},
+	}
+
+	if insert.Email.Confirmed {
+		insert.Email.ConfirmedAt = now
+	}
+
+	if util.ValidateDisplayName(claims.Name) {
+		insert.Metadata.Profile.DisplayName = claims.Name
+	}
+
+	id, err := db.InsertDocument(mongop, insert)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to insert account document: "+err.Error())
+		return
+	}
+
+	if !insert.Email.Confirmed {
+		if err := ac.sendEmailConfirmation(id, claims.Email); err != nil {
+			fmt.Println("Failed to send email confirmation: " + err.Error())
+		}
+	}
+
+	accesstoken, refreshtoken, err := ac.issueTokens(ctx, id, insert.Roles, "")
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, response.AccountCreateResponse{
+		ID:           id,
+		AccessToken:  accesstoken,
+		RefreshToken: refreshtoken,
+	})
+}
+
+// generateUsername derives an available username from the preferred
+// username or email address shared by a provider.
+func (ac *AccountController) generateUsername(preferred string, email string) (string, error) {
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	base := preferred
+	if len(base) == 0 {
+		base, _, _ = strings.Cut(email, "@")
+	}
+
+	base = strings.TrimLeft(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '_' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(base)), "._")
+	if len(base) > 20 {
+		base = base[:20]
+	}
+
+	if len(base) == 0 {
+		base = "athlete"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		_, err := db.FindDocumentByKeyValue[string, model.Account](mongop, "username", candidate)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return "", fmt.Errorf("failed to perform username lookup: %w", err)
+		}
+
+		if err == mongo.ErrNoDocuments {
+			reserved, err := ac.identifierReserved(model.ReservedUsername, candidate)
+			if err != nil {
+				return "", err
+			}
+
+			if !reserved {
+				return candidate, nil
+			}
+		}
+
+		suffix, err := util.GenerateRandomString(2)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate username: %w", err)
+		}
+
+		candidate = base + "_" + suffix
+	}
+
+	return "", fmt.Errorf("failed to find an available username")
+}
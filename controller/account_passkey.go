@@ -0,0 +1,453 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"strings"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/response"
+	"tc-server/util"
+	"tc-server/webauthn"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// passkeyRegisterPrefix prefixes the cache key holding the challenge
+	// of a pending registration ceremony of an account.
+	passkeyRegisterPrefix = "passkey_register:"
+
+	// passkeyLoginPrefix prefixes the cache key holding a pending login
+	// ceremony.
+	passkeyLoginPrefix = "passkey_login:"
+)
+
+// BeginPasskeyRegistration starts a registration ceremony for a new
+// passkey of the requesting account and returns the credential creation
+// options for navigator.credentials.create. Passkeys sign in without a
+// second factor, so the account owner has to reauthenticate first.
+func (ac *AccountController) BeginPasskeyRegistration() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		conf := ac.GlobalController.Config
+
+		var req request.ReauthRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		if !ac.reauthenticate(ctx, account, req) {
+			return
+		}
+
+		challenge, err := webauthn.NewChallenge()
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate challenge: "+err.Error())
+			return
+		}
+
+		id := account.ID.Hex()
+		_, err = db.SetCacheValue(redisp, passkeyRegisterPrefix+id, challenge, conf.WebAuthn.ChallengeTTL*60)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to cache challenge: "+err.Error())
+			return
+		}
+
+		displayName := account.Metadata.Profile.DisplayName
+		if len(displayName) == 0 {
+			displayName = account.Username
+		}
+
+		var exclude [][]byte
+		for _, passkey := range account.Passkeys {
+			if raw, err := webauthn.DecodeBase64URL(passkey.ID); err == nil {
+				exclude = append(exclude, raw)
+			}
+		}
+
+		ctx.JSON(http.StatusOK, ac.GlobalController.WebAuthn.CreationOptions(challenge, webauthn.UserEntity{
+			ID:          webauthn.EncodeBase64URL([]byte(id)),
+			Name:        account.Username,
+			DisplayName: displayName,
+		}, exclude, conf.WebAuthn.ChallengeTTL*60*1000))
+	}
+}
+
+// FinishPasskeyRegistration verifies the credential created by the
+// browser for the pending registration ceremony and stores it as a
+// passkey of the requesting account.
+func (ac *AccountController) FinishPasskeyRegistration() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		var req request.PasskeyRegisterRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		name := strings.TrimSpace(req.Name)
+		if len(name) == 0 {
+			name = "Passkey"
+		}
+
+		if utf8.RuneCountInString(name) > 64 {
+			util.CreateError(ctx, http.StatusBadRequest, "passkey name is too long")
+			return
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		challenge, err := db.GetAndDeleteCacheValue(redisp, passkeyRegisterPrefix+account.ID.Hex())
+		if err == redis.Nil {
+			util.CreateError(ctx, http.StatusBadRequest, "registration not found or expired")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform challenge lookup: "+err.Error())
+			return
+		}
+
+		clientData, err1 := webauthn.DecodeBase64URL(req.Credential.Response.ClientDataJSON)
+		attestation, err2 := webauthn.DecodeBase64URL(req.Credential.Response.AttestationObject)
+		if err1 != nil || err2 != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid credential encoding")
+			return
+		}
+
+		credential, err := ac.GlobalController.WebAuthn.VerifyRegistration(challenge, clientData, attestation)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid credential: "+err.Error())
+			return
+		}
+
+		passkey := model.Passkey{
+			ID:        webauthn.EncodeBase64URL(credential.ID),
+			Name:      name,
+			PublicKey: credential.PublicKey,
+			SignCount: credential.SignCount,
+			CreatedAt: time.Now(),
+		}
+
+		_, err = db.FindDocumentByKeyValue[string, model.Account](mongop, "passkeys.id", passkey.ID)
+		if err == nil {
+			util.CreateError(ctx, http.StatusConflict, "passkey is already registered")
+			return
+		}
+
+		if err != mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform passkey lookup: "+err.Error())
+			return
+		}
+
+		// The credential is checked again by the update itself, so
+		// concurrent registrations can not store it twice on the account.
+		result, err := db.UpdateDocumentMatching(mongop, bson.M{
+			"_id":         account.ID,
+			"passkeys.id": bson.M{"$ne": passkey.ID},
+		}, bson.M{
+			"$push": bson.M{"passkeys": passkey},
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to store passkey: "+err.Error())
+			return
+		}
+
+		if result.ModifiedCount == 0 {
+			util.CreateError(ctx, http.StatusConflict, "passkey is already registered")
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "passkeys", "", passkey.ID)
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusCreated, passkey)
+	}
+}
+
+// ListPasskeys returns the passkeys registered to the requesting account.
+func (ac *AccountController) ListPasskeys() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		passkeys := account.Passkeys
+		if passkeys == nil {
+			passkeys = []model.Passkey{}
+		}
+
+		ctx.JSON(http.StatusOK, passkeys)
+	}
+}
+
+// DeletePasskey removes a passkey from the requesting account.
+func (ac *AccountController) DeletePasskey() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		credentialId := ctx.Param("credentialId")
+		result, err := db.UpdateDocumentByFilter[model.Account](mongop, account.ID, bson.M{
+			"$pull": bson.M{"passkeys": bson.M{"id": credentialId}},
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to remove passkey: "+err.Error())
+			return
+		}
+
+		if result.ModifiedCount == 0 {
+			util.CreateError(ctx, http.StatusNotFound, "passkey not found")
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "passkeys", credentialId, "")
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// BeginPasskeyLogin starts a login ceremony and returns the credential
+// request options for navigator.credentials.get alongside the session ID
+// the ceremony is finished with. If an identifier is provided only
+// passkeys of the matching account are allowed.
+func (ac *AccountController) BeginPasskeyLogin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+		conf := ac.GlobalController.Config
+
+		var req request.PasskeyLoginBeginRequest
+		if ctx.Request.ContentLength != 0 {
+			if err := ctx.ShouldBindJSON(&req); err != nil {
+				util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+				return
+			}
+		}
+
+		challenge, err := webauthn.NewChallenge()
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate challenge: "+err.Error())
+			return
+		}
+
+		session := model.PasskeyChallenge{Challenge: challenge}
+		var allow [][]byte
+
+		// An unknown identifier falls back to a discoverable login so the
+		// response does not reveal whether the identifier is registered.
+		if len(req.Identifier) > 0 {
+			key := "username"
+			if util.ValidateEmail(req.Identifier) {
+				key = "email.value"
+			}
+
+			account, err := db.FindDocumentByKeyValue[string, model.Account](mongop, key, req.Identifier)
+			if err != nil && err != mongo.ErrNoDocuments {
+				util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+				return
+			}
+
+			if err == nil && len(account.Passkeys) > 0 {
+				session.AccountID = account.ID.Hex()
+				for _, passkey := range account.Passkeys {
+					if raw, err := webauthn.DecodeBase64URL(passkey.ID); err == nil {
+						allow = append(allow, raw)
+					}
+				}
+			}
+		}
+
+		sessionId, err := util.GenerateRandomString(32)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate session id: "+err.Error())
+			return
+		}
+
+		raw, err := json.Marshal(session)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to encode challenge: "+err.Error())
+			return
+		}
+
+		_, err = db.SetCacheValue(redisp, passkeyLoginPrefix+sessionId, string(raw), conf.WebAuthn.ChallengeTTL*60)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to cache challenge: "+err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.PasskeyLoginBeginResponse{
+			SessionID: sessionId,
+			Options:   ac.GlobalController.WebAuthn.RequestOptions(challenge, allow, conf.WebAuthn.ChallengeTTL*60*1000),
+		})
+	}
+}
+
+// FinishPasskeyLogin verifies the assertion created by the browser for a
+// pending login ceremony and returns a new token pair. Passkeys require
+// user verification and therefore skip the TOTP step-up.
+func (ac *AccountController) FinishPasskeyLogin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		var req request.PasskeyLoginRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		raw, err := db.GetAndDeleteCacheValue(redisp, passkeyLoginPrefix+req.SessionID)
+		if err == redis.Nil {
+			util.CreateError(ctx, http.StatusBadRequest, "login not found or expired")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform challenge lookup: "+err.Error())
+			return
+		}
+
+		var session model.PasskeyChallenge
+		if err := json.Unmarshal([]byte(raw), &session); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to parse challenge: "+err.Error())
+			return
+		}
+
+		credentialId, err := webauthn.DecodeBase64URL(req.Credential.ID)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid credential encoding")
+			return
+		}
+
+		id := webauthn.EncodeBase64URL(credentialId)
+		account, err := db.FindDocumentByKeyValue[string, model.Account](mongop, "passkeys.id", id)
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		if len(session.AccountID) > 0 && session.AccountID != account.ID.Hex() {
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+
+		if len(req.Credential.Response.UserHandle) > 0 {
+			handle, err := webauthn.DecodeBase64URL(req.Credential.Response.UserHandle)
+			if err != nil || string(handle) != account.ID.Hex() {
+				util.CreateError(ctx, http.StatusUnauthorized, "invalid credentials")
+				return
+			}
+		}
+
+		var passkey model.Passkey
+		for _, p := range account.Passkeys {
+			if p.ID == id {
+				passkey = p
+			}
+		}
+
+		clientData, err1 := webauthn.DecodeBase64URL(req.Credential.Response.ClientDataJSON)
+		authData, err2 := webauthn.DecodeBase64URL(req.Credential.Response.AuthenticatorData)
+		signature, err3 := webauthn.DecodeBase64URL(req.Credential.Response.Signature)
+		if err1 != nil || err2 != nil || err3 != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid credential encoding")
+			return
+		}
+
+		signCount, err := ac.GlobalController.WebAuthn.VerifyAssertion(session.Challenge, passkey.PublicKey, passkey.SignCount, clientData, authData, signature)
+		if errors.Is(err, webauthn.ErrCloned) {
+			entry := ac.GlobalController.newAuditEntry(ctx, account, "passkeys.sign_count", fmt.Sprint(passkey.SignCount), "possible clone of "+passkey.ID)
+			if err := ac.GlobalController.recordAudit(entry); err != nil {
+				fmt.Println("Failed to record audit entry: " + err.Error())
+			}
+
+			util.CreateError(ctx, http.StatusUnauthorized, "passkey may have been cloned")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid credentials")
+			return
+		}
+
+		_, err = db.UpdateDocumentMatching(mongop, bson.M{"_id": account.ID, "passkeys.id": id}, bson.M{
+			"$set": bson.M{
+				"passkeys.$.sign_count":   signCount,
+				"passkeys.$.last_used_at": time.Now(),
+			},
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to update passkey: "+err.Error())
+			return
+		}
+
+		accountId := account.ID.Hex()
+		accesstoken, refreshtoken, err := ac.issueTokens(ctx, accountId, account.EffectiveRoles(), "")
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.AccountLoginResponse{
+			ID:           accountId,
+			AccessToken:  accesstoken,
+			RefreshToken: refreshtoken,
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/url"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/util"
+)
+
+const (
+	// passwordResetPrefix prefixes the cache key of a password reset token.
+	passwordResetPrefix = "password_reset:"
+
+	// accountPasswordResetsPrefix prefixes the cache key of the set of
+	// reset tokens issued to an account.
+	accountPasswordResetsPrefix = "account_password_resets:"
+)
+
+// ForgotPassword sends a single-use password reset link to the provided
+// email address, revoking any link sent before. The account lookup and
+// the link are handled after responding, so neither the response nor
+// its timing reveal whether an account uses the address.
+func (ac *AccountController) ForgotPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var req request.ForgotPasswordRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		if !util.ValidateEmail(req.Email) {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid email")
+			return
+		}
+
+		go ac.sendPasswordResetTo(req.Email)
+
+		ctx.Status(http.StatusAccepted)
+	}
+}
+
+// sendPasswordResetTo sends a password reset link to the account using
+// the email address, if any. Failures are logged since the request was
+// already answered.
+func (ac *AccountController) sendPasswordResetTo(email string) {
+	account, err := db.FindDocumentByKeyValue[string, model.Account](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}, "email.value", email)
+	if err == mongo.ErrNoDocuments {
+		return
+	}
+
+	if err != nil {
+		fmt.Println("Failed to perform password reset lookup: " + err.Error())
+		return
+	}
+
+	if err := ac.sendPasswordReset(account); err != nil {
+		fmt.Println("Failed to send password reset: " + err.Error())
+	}
+}
+
+// sendPasswordReset issues a new reset token to the account, revoking
+// every token issued before, and mails the reset link to it.
+func (ac *AccountController) sendPasswordReset(account model.Account) error {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+	conf := ac.GlobalController.Config
+
+	id := account.ID.Hex()
+	if err := ac.revokePasswordResets(id); err != nil {
+		return err
+	}
+
+	token, err := util.GenerateRandomString(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	ttl := conf.Auth.PasswordResetTTL * 60
+	_, err = db.SetCacheValue(redisp, passwordResetPrefix+token, id, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to cache reset token: %w", err)
+	}
+
+	err = db.AddCacheSetMember(redisp, accountPasswordResetsPrefix+id, token, ttl)
+	if err != nil {
+		return fmt.Errorf("failed to index reset token: %w", err)
+	}
+
+	return ac.GlobalController.Mail.Enqueue(account.Email.Value, "password_reset", map[string]any{
+		"Link": conf.Auth.PasswordResetURL + "?token=" + url.QueryEscape(token),
+		"TTL":  conf.Auth.PasswordResetTTL,
+	})
+}
+
+// ResetPassword replaces the password of the account a reset token was
+// issued for. The token is consumed by the request and every token
+// previously issued to the account is revoked.
+func (ac *AccountController) ResetPassword() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		var req request.ResetPasswordRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		if len(req.Token) == 0 {
+			util.CreateError(ctx, http.StatusBadRequest, "missing reset token")
+			return
+		}
+
+		id, err := db.GetCacheValue(redisp, passwordResetPrefix+req.Token)
+		if err == redis.Nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid or expired reset token")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform reset token lookup: "+err.Error())
+			return
+		}
+
+		objectId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "invalid account id: "+err.Error())
+			return
+		}
+
+		account, err := db.FindDocumentById[model.Account](mongop, id)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		// The password is validated before the token is consumed so a
+		// rejected password does not burn the reset link.
+		if !ac.validatePassword(ctx, req.Password, account.Username, account.Email.Value) {
+			return
+		}
+
+		_, err = db.GetAndDeleteCacheValue(redisp, passwordResetPrefix+req.Token)
+		if err == redis.Nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid or expired reset token")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to consume reset token: "+err.Error())
+			return
+		}
+
+		if err := ac.revokePasswordResets(id); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		hash, err := ac.GlobalController.Passwords.Hash(req.Password)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to generate hash: "+err.Error())
+			return
+		}
+
+		_, err = db.UpdateDocument(mongop, objectId, "password", hash)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to update password: "+err.Error())
+			return
+		}
+
+		if err := ac.revokeAllSessions(id); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// revokePasswordResets invalidates every reset token issued to the
+// account.
+func (ac *AccountController) revokePasswordResets(accountId string) error {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	err := db.DeleteCacheSetWithMembers(redisp, accountPasswordResetsPrefix+accountId, passwordResetPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to revoke reset tokens: %w", err)
+	}
+
+	return nil
+}
+
+// rehashPassword replaces the stored password hash of an account with a
+// hash using the configured algorithm and parameters. The update only
+// applies if the stored hash has not changed since the account was
+// loaded, so a concurrent password reset is never overwritten.
+func (ac *AccountController) rehashPassword(account model.Account, password string) error {
+	mongop := db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}
+
+	hash, err := ac.GlobalController.Passwords.Hash(password)
+	if err != nil {
+		return fmt.Errorf("failed to generate hash: %w", err)
+	}
+
+	_, err = db.UpdateDocumentMatching(mongop,
+		bson.M{"_id": account.ID, "password": account.Password},
+		bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+// validatePassword checks a new password against the password policy and
+// responds with the reasons it was rejected, if any. Identities are the
+// username and email of the account the password is chosen for.
+func (ac *AccountController) validatePassword(ctx *gin.Context, password string, identities ...string) bool {
+	rejections, err := ac.GlobalController.Policy.Validate(password, identities...)
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to validate password: "+err.Error())
+		return false
+	}
+
+	if len(rejections) > 0 {
+		util.CreateErrorWithDetails(ctx, http.StatusBadRequest, "invalid password", rejections)
+		return false
+	}
+
+	return true
+}
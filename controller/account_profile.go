@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/response"
+	"tc-server/util"
+	"time"
+)
+
+// auditCollectionName is the collection account audit entries are stored in.
+const auditCollectionName = "account_audit"
+
+// UpdateProfile applies a partial update to the profile of the requesting
+// account. Every changed field is recorded in the account audit log.
+func (ac *AccountController) UpdateProfile() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+
+		var req request.UpdateProfileRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		if req.DisplayName != nil && len(*req.DisplayName) > 0 && !util.ValidateDisplayName(*req.DisplayName) {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid display name")
+			return
+		}
+
+		if req.Avatar != nil && len(*req.Avatar) > 0 && !util.ValidateURL(*req.Avatar) {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid avatar url")
+			return
+		}
+
+		account, err := db.FindDocumentById[model.Account](mongop, ctx.GetString("accountId"))
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusNotFound, "account not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		profile := &account.Metadata.Profile
+		changes := []struct {
+			field    string
+			current  *string
+			proposed *string
+		}{
+			{"metadata.profile.display_name", &profile.DisplayName, req.DisplayName},
+			{"metadata.profile.avatar", &profile.Avatar, req.Avatar},
+		}
+
+		avatarChanged := req.Avatar != nil && *req.Avatar != profile.Avatar
+
+		set := bson.M{}
+		unset := bson.M{}
+		var entries []model.AccountAuditEntry
+		for _, change := range changes {
+			if change.proposed == nil || *change.proposed == *change.current {
+				continue
+			}
+
+			if len(*change.proposed) == 0 {
+				unset[change.field] = ""
+			} else {
+				set[change.field] = *change.proposed
+			}
+
+			entries = append(entries, ac.GlobalController.newAuditEntry(ctx, account, change.field, *change.current, *change.proposed))
+			*change.current = *change.proposed
+		}
+
+		// Replacing the avatar by URL discards any uploaded renditions.
+		var previous []string
+		if avatarChanged && len(profile.AvatarKeys) > 0 {
+			previous = profile.AvatarKeys
+			unset["metadata.profile.avatars"] = ""
+			unset["metadata.profile.avatar_keys"] = ""
+			profile.Avatars = nil
+			profile.AvatarKeys = nil
+		}
+
+		if len(entries) == 0 {
+			ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
+			return
+		}
+
+		update := bson.M{}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+
+		_, err = db.UpdateDocumentByFilter[model.Account](mongop, account.ID, update)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to update profile: "+err.Error())
+			return
+		}
+
+		if err := ac.GlobalController.recordAudit(entries...); err != nil {
+			fmt.Println("Failed to record profile audit: " + err.Error())
+		}
+
+		ac.deleteAvatarBlobs(previous)
+		ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
+	}
+}
+
+// newAuditEntry creates an audit entry for a change made to the provided
+// account by the requester.
+func (c *GlobalController) newAuditEntry(ctx *gin.Context, account model.Account, field string, oldValue string, newValue string) model.AccountAuditEntry {
+	entry := model.AccountAuditEntry{
+		AccountID: account.ID,
+		ActorID:   account.ID,
+		Field:     field,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+		CreatedAt: time.Now(),
+	}
+
+	if actor, err := primitive.ObjectIDFromHex(ctx.GetString("accountId")); err == nil {
+		entry.ActorID = actor
+	}
+
+	return entry
+}
+
+// recordAudit stores the provided audit entries.
+func (c *GlobalController) recordAudit(entries ...model.AccountAuditEntry) error {
+	mongop := db.MongoParams{
+		Client:         c.Mongo,
+		DBName:         c.Config.Mongo.DatabaseName,
+		CollectionName: auditCollectionName,
+	}
+
+	for _, entry := range entries {
+		if _, err := db.InsertDocument(mongop, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
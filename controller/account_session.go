@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"net/http"
+	"sort"
+	"tc-server/db"
+	"tc-server/middleware"
+	"tc-server/model"
+	"tc-server/response"
+	"tc-server/util"
+	"time"
+)
+
+// ListSessions returns the active sessions of the requesting account,
+// most recently used first.
+func (ac *AccountController) ListSessions() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetString("accountId")
+		current := ctx.GetString("sessionId")
+
+		sessions, err := ac.accountSessions(id)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		})
+
+		res := make([]response.SessionResponse, 0, len(sessions))
+		for _, session := range sessions {
+			res = append(res, response.NewSessionResponse(session, session.ID == current))
+		}
+
+		ctx.JSON(http.StatusOK, res)
+	}
+}
+
+// RevokeSession signs the requesting account out of one of its sessions.
+// Revoking the current session also revokes the requesting access token
+// and clears the refresh cookie.
+func (ac *AccountController) RevokeSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+		sessionId := ctx.Param("sessionId")
+
+		session, err := ac.getSession(sessionId)
+		if err == redis.Nil || (err == nil && session.AccountID != ctx.GetString("accountId")) {
+			util.CreateError(ctx, http.StatusNotFound, "session not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := ac.revokeSession(sessionId); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if sessionId == ctx.GetString("sessionId") {
+			ttl := int(time.Until(ctx.GetTime("tokenExpiresAt")).Seconds()) + 1
+			if err := middleware.RevokeToken(redisp, ctx.GetString("tokenId"), ttl); err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, "failed to revoke access token: "+err.Error())
+				return
+			}
+
+			ac.setRefreshCookie(ctx, "", -1)
+		}
+
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// accountSessions returns the active sessions of an account. Sessions
+// that expired since they were added to the account are pruned along the
+// way.
+func (ac *AccountController) accountSessions(accountId string) ([]model.Session, error) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	sessionIds, err := db.GetCacheSetMembers(redisp, accountSessionsPrefix+accountId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := []model.Session{}
+	for _, sessionId := range sessionIds {
+		session, err := ac.getSession(sessionId)
+		if err == redis.Nil {
+			if err := db.RemoveCacheSetMember(redisp, accountSessionsPrefix+accountId, sessionId); err != nil {
+				return nil, fmt.Errorf("failed to prune session: %w", err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// getSession loads a session from the cache. redis.Nil is returned if
+// the session does not exist.
+func (ac *AccountController) getSession(sessionId string) (model.Session, error) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	var session model.Session
+	raw, err := db.GetCacheValue(redisp, sessionPrefix+sessionId)
+	if err == redis.Nil {
+		return session, err
+	}
+
+	if err != nil {
+		return session, fmt.Errorf("failed to perform session lookup: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		return session, fmt.Errorf("failed to parse session: %w", err)
+	}
+
+	return session, nil
+}
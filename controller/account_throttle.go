@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"strings"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/util"
+	"time"
+)
+
+const (
+	// loginFailuresPrefix prefixes the cache key counting failed logins
+	// of an account, second factor, identifier or IP address within the
+	// window.
+	loginFailuresPrefix = "login_failures:"
+
+	// loginLockoutPrefix prefixes the cache key blocking logins of an
+	// account, second factor, identifier or IP address until it expires.
+	loginLockoutPrefix = "login_lockout:"
+)
+
+// loginSubjects returns the throttling subjects of a login attempt with
+// their failure limits. Unknown identifiers are throttled like accounts
+// so lockouts do not reveal whether an identifier is registered.
+func (ac *AccountController) loginSubjects(ctx *gin.Context, account *model.Account, identifier string) map[string]int {
+	conf := ac.GlobalController.Config.Auth.LoginThrottle
+
+	subject := "identifier:" + strings.ToLower(identifier)
+	if account != nil {
+		subject = "account:" + account.ID.Hex()
+	}
+
+	return map[string]int{
+		"ip:" + ctx.ClientIP(): conf.MaxIPFailures,
+		subject:                conf.MaxAccountFailures,
+	}
+}
+
+// secondFactorSubjects returns the throttling subjects of a second
+// factor attempt with their failure limits. Failures are counted apart
+// from password failures so proving the password again does not reset
+// them.
+func (ac *AccountController) secondFactorSubjects(ctx *gin.Context, account model.Account) map[string]int {
+	conf := ac.GlobalController.Config.Auth.LoginThrottle
+
+	return map[string]int{
+		"ip:" + ctx.ClientIP():              conf.MaxIPFailures,
+		"second_factor:" + account.ID.Hex(): conf.MaxAccountFailures,
+	}
+}
+
+// checkLoginLockout responds with 429 and a Retry-After header if any of
+// the subjects is locked out. It returns false if the login must not
+// proceed.
+func (ac *AccountController) checkLoginLockout(ctx *gin.Context, subjects map[string]int) bool {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	var remaining time.Duration
+	for subject := range subjects {
+		ttl, err := db.GetCacheTTL(redisp, loginLockoutPrefix+subject)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to query login lockout: "+err.Error())
+			return false
+		}
+
+		remaining = max(remaining, ttl)
+	}
+
+	if remaining <= 0 {
+		return true
+	}
+
+	ctx.Header("Retry-After", fmt.Sprint(int(math.Ceil(remaining.Seconds()))))
+	util.CreateError(ctx, http.StatusTooManyRequests, "too many failed login attempts, please try again later")
+	return false
+}
+
+// recordLoginFailure counts a failed login for every subject and locks
+// out subjects which reached their failure limit, doubling the lockout
+// for every further failure. The account owner is notified by mail the
+// first time the account is locked within a window. Lockouts are reported
+// through checkLoginLockout.
+func (ac *AccountController) recordLoginFailure(ctx *gin.Context, account *model.Account, subjects map[string]int) error {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+	conf := ac.GlobalController.Config.Auth.LoginThrottle
+
+	for subject, limit := range subjects {
+		failures, err := db.IncrementCacheCounter(redisp, loginFailuresPrefix+subject, conf.Window*60)
+		if err != nil {
+			return fmt.Errorf("failed to count login failure: %w", err)
+		}
+
+		if limit <= 0 || failures < int64(limit) {
+			continue
+		}
+
+		lockout := util.Backoff(
+			time.Duration(conf.BaseLockout)*time.Second,
+			int(failures)-limit+1,
+			time.Duration(conf.MaxLockout)*time.Second,
+		)
+
+		// A lockout stored without expiry would never end.
+		ttl := max(int(math.Ceil(lockout.Seconds())), 1)
+		_, err = db.SetCacheValue(redisp, loginLockoutPrefix+subject, 1, ttl)
+		if err != nil {
+			return fmt.Errorf("failed to lock out login: %w", err)
+		}
+
+		owned := strings.HasPrefix(subject, "account:") || strings.HasPrefix(subject, "second_factor:")
+		if account != nil && failures == int64(limit) && owned {
+			err := ac.GlobalController.Mail.Enqueue(account.Email.Value, "account_locked", map[string]any{
+				"IP":      ctx.ClientIP(),
+				"Minutes": int(math.Ceil(lockout.Minutes())),
+			})
+			if err != nil {
+				fmt.Println("Failed to send lockout notification: " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// resetLoginFailures clears the failures and lockout of an account after
+// a successful login. IP address failures are kept so a successful login
+// does not reset credential stuffing across accounts.
+func (ac *AccountController) resetLoginFailures(account model.Account) error {
+	return ac.clearLoginFailures("account:" + account.ID.Hex())
+}
+
+// resetSecondFactorFailures clears the second factor failures and
+// lockout of an account after a second factor was verified.
+func (ac *AccountController) resetSecondFactorFailures(account model.Account) error {
+	return ac.clearLoginFailures("second_factor:" + account.ID.Hex())
+}
+
+// clearLoginFailures clears the failures and lockout of a subject.
+func (ac *AccountController) clearLoginFailures(subject string) error {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	if _, err := db.DeleteCacheValue(redisp, loginFailuresPrefix+subject); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+
+	if _, err := db.DeleteCacheValue(redisp, loginLockoutPrefix+subject); err != nil {
+		return fmt.Errorf("failed to reset login lockout: %w", err)
+	}
+
+	return nil
+}
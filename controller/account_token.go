@@ -0,0 +1,417 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"strings"
+	"tc-server/db"
+	"tc-server/middleware"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/response"
+	"tc-server/util"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// sessionPrefix prefixes the cache key holding a session. Sessions are
+	// keyed by the family ID of their refresh tokens.
+	sessionPrefix = "session:"
+
+	// accountSessionsPrefix prefixes the cache key holding the set of
+	// session IDs of an account.
+	accountSessionsPrefix = "account_sessions:"
+
+	// refreshTokenPrefix prefixes the cache key marking a refresh token ID
+	// as unused. Each refresh token may only be rotated once.
+	refreshTokenPrefix = "refresh_token:"
+)
+
+// errSessionRevoked is returned by issueTokens if the session of a token
+// family was revoked while its refresh token was being rotated.
+var errSessionRevoked = errors.New("session has been revoked")
+
+// RefreshToken exchanges a refresh token, provided either through the
+// refresh_token cookie or the request body, for a new token pair. Each
+// refresh token may only be used once. Presenting an already rotated
+// token revokes the entire token family it belongs to.
+func (ac *AccountController) RefreshToken() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+		token, ok := refreshTokenFromRequest(ctx)
+		if !ok {
+			return
+		}
+
+		claims, err := util.ValidateTokenClaims(token, ac.GlobalController.RefreshKey)
+		if err != nil || len(claims.FamilyID) == 0 {
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+
+		// Consuming the token atomically guarantees concurrent requests
+		// can never both rotate the same refresh token.
+		owner, err := db.GetAndDeleteCacheValue(redisp, refreshTokenPrefix+claims.ID)
+		if err == redis.Nil {
+			if err := ac.revokeSession(claims.FamilyID); err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			util.CreateError(ctx, http.StatusUnauthorized, "refresh token has been revoked")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform refresh token lookup: "+err.Error())
+			return
+		}
+
+		if owner != claims.AccountID {
+			util.CreateError(ctx, http.StatusUnauthorized, "invalid refresh token")
+			return
+		}
+
+		generation, err := middleware.GetTokenGeneration(redisp, claims.AccountID)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to query token generation: "+err.Error())
+			return
+		}
+
+		if claims.Generation < generation {
+			if err := ac.revokeSession(claims.FamilyID); err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
+
+			util.CreateError(ctx, http.StatusUnauthorized, "refresh token has been revoked")
+			return
+		}
+
+		// Roles are read from the account rather than the presented token
+		// so role changes apply on the next rotation.
+		account, err := db.FindDocumentById[model.Account](db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}, claims.AccountID)
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusUnauthorized, "account not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		accesstoken, refreshtoken, err := ac.issueTokens(ctx, claims.AccountID, account.EffectiveRoles(), claims.FamilyID)
+		if err == errSessionRevoked {
+			util.CreateError(ctx, http.StatusUnauthorized, "refresh token has been revoked")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.AccountRefreshResponse{
+			ID:           claims.AccountID,
+			AccessToken:  accesstoken,
+			RefreshToken: refreshtoken,
+		})
+	}
+}
+
+// Logout revokes the session of the refresh token provided through the
+// refresh_token cookie or the request body and clears the refresh
+// cookie. If the request also carries a valid access token it is added
+// to the revocation list.
+func (ac *AccountController) Logout() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+		token, ok := refreshTokenFromRequest(ctx)
+		if !ok {
+			return
+		}
+
+		claims, err := util.ValidateTokenClaims(token, ac.GlobalController.RefreshKey)
+		if err == nil {
+			if err := ac.revokeSession(claims.FamilyID); err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+
+		if access, err := middleware.BearerToken(ctx); err == nil {
+			claims, err := util.ValidateTokenClaims(access, ac.GlobalController.AccessKeys)
+			if err == nil {
+				ttl := int(time.Until(claims.ExpiresAt.Time).Seconds()) + 1
+				if err := middleware.RevokeToken(redisp, claims.ID, ttl); err != nil {
+					util.CreateError(ctx, http.StatusInternalServerError, "failed to revoke access token: "+err.Error())
+					return
+				}
+			}
+		}
+
+		ac.setRefreshCookie(ctx, "", -1)
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// LogoutAll revokes every access and refresh token issued to the
+// requesting account by advancing its token generation and removes all
+// of its sessions.
+func (ac *AccountController) LogoutAll() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := ac.revokeAllSessions(ctx.GetString("accountId")); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		ac.setRefreshCookie(ctx, "", -1)
+		ctx.Status(http.StatusNoContent)
+	}
+}
+
+// issueTokens generates a new access and refresh token pair for the
+// provided account ID carrying the provided roles and the scopes they
+// grant in its access token. An empty familyId starts a new token family
+// and with it a new session, otherwise the session of the family is
+// updated. The access token carries the time the session was created as
+// its auth_time, which is kept when the tokens are rotated. The refresh
+// token is attached to the response as an HTTP-only
+// cookie.
+func (ac *AccountController) issueTokens(ctx *gin.Context, id string, roles []string, familyId string) (string, string, error) {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+	conf := ac.GlobalController.Config
+	now := time.Now()
+
+	var session model.Session
+	if len(familyId) == 0 {
+		fid, err := util.GenerateRandomString(16)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate token family: %w", err)
+		}
+
+		session = model.Session{
+			ID:         fid,
+			AccountID:  id,
+			DeviceName: deviceName(ctx),
+			CreatedAt:  now,
+		}
+	} else {
+		raw, err := db.GetCacheValue(redisp, sessionPrefix+familyId)
+		if err == redis.Nil {
+			return "", "", errSessionRevoked
+		}
+
+		if err != nil {
+			return "", "", fmt.Errorf("failed to perform session lookup: %w", err)
+		}
+
+		if err := json.Unmarshal([]byte(raw), &session); err != nil {
+			return "", "", fmt.Errorf("failed to parse session: %w", err)
+		}
+	}
+
+	session.UserAgent = ctx.Request.UserAgent()
+	session.IP = ctx.ClientIP()
+	session.LastUsedAt = now
+
+	generation, err := middleware.GetTokenGeneration(redisp, id)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to query token generation: %w", err)
+	}
+
+	roleGeneration, err := middleware.GetRoleGeneration(redisp, id)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to query role generation: %w", err)
+	}
+
+	accesstoken, err := util.GenerateTokenWithClaims(util.Claims{
+		AccountID:      id,
+		FamilyID:       session.ID,
+		Generation:     generation,
+		RoleGeneration: roleGeneration,
+		Roles:          roles,
+		Scopes:         model.ScopesForRoles(roles),
+		AuthTime:       session.CreatedAt.Unix(),
+	}, ac.GlobalController.AccessKeys.Current, conf.Auth.AccessTokenTTL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	session.TokenID, err = util.GenerateRandomString(16)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token id: %w", err)
+	}
+
+	refreshtoken, err := util.GenerateTokenWithClaims(util.Claims{
+		AccountID:        id,
+		FamilyID:         session.ID,
+		Generation:       generation,
+		RegisteredClaims: jwt.RegisteredClaims{ID: session.TokenID},
+	}, ac.GlobalController.RefreshKey, conf.Auth.RefreshTokenTTL)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	// Token TTLs are configured in minutes while the cache and cookie
+	// expect seconds.
+	ttl := conf.Auth.RefreshTokenTTL * 60
+
+	_, err = db.SetCacheValue(redisp, refreshTokenPrefix+session.TokenID, id, ttl)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to cache refresh token: %w", err)
+	}
+
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	_, err = db.SetCacheValue(redisp, sessionPrefix+session.ID, string(raw), ttl)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to cache session: %w", err)
+	}
+
+	if err := db.AddCacheSetMember(redisp, accountSessionsPrefix+id, session.ID, ttl); err != nil {
+		return "", "", fmt.Errorf("failed to cache account session: %w", err)
+	}
+
+	ac.setRefreshCookie(ctx, refreshtoken, ttl)
+	return accesstoken, refreshtoken, nil
+}
+
+// revokeSession removes a session together with the latest refresh token
+// of its family, invalidating every refresh token issued within it.
+// Access tokens of the session stay valid until they expire.
+func (ac *AccountController) revokeSession(sessionId string) error {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	raw, err := db.GetAndDeleteCacheValue(redisp, sessionPrefix+sessionId)
+	if err == redis.Nil {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	var session model.Session
+	if err := json.Unmarshal([]byte(raw), &session); err != nil {
+		return fmt.Errorf("failed to parse session: %w", err)
+	}
+
+	_, err = db.DeleteCacheValue(redisp, refreshTokenPrefix+session.TokenID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token: %w", err)
+	}
+
+	if err := db.RemoveCacheSetMember(redisp, accountSessionsPrefix+session.AccountID, sessionId); err != nil {
+		return fmt.Errorf("failed to remove account session: %w", err)
+	}
+
+	return nil
+}
+
+// revokeAllSessions revokes every access and refresh token issued to an
+// account by advancing its token generation and removes its sessions.
+func (ac *AccountController) revokeAllSessions(accountId string) error {
+	redisp := db.RedisParams{RedisClient: ac.GlobalController.Redis}
+
+	if err := middleware.RevokeAccountTokens(redisp, accountId); err != nil {
+		return fmt.Errorf("failed to revoke account tokens: %w", err)
+	}
+
+	sessions, err := db.GetCacheSetMembers(redisp, accountSessionsPrefix+accountId)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	for _, sessionId := range sessions {
+		if err := ac.revokeSession(sessionId); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.DeleteCacheValue(redisp, accountSessionsPrefix+accountId)
+	if err != nil {
+		return fmt.Errorf("failed to remove account sessions: %w", err)
+	}
+
+	return nil
+}
+
+// deviceName returns the name of the device sending the request. Native
+// clients name themselves through the X-Device-Name header, otherwise
+// the name is derived from the user agent.
+func deviceName(ctx *gin.Context) string {
+	name := strings.TrimSpace(ctx.GetHeader("X-Device-Name"))
+	if len(name) == 0 {
+		return util.DeviceName(ctx.Request.UserAgent())
+	}
+
+	if utf8.RuneCountInString(name) > 64 {
+		name = string([]rune(name)[:64])
+	}
+
+	return name
+}
+
+// refreshTokenFromRequest reads the refresh token from the refresh_token
+// cookie, falling back to the request body. If no token could be found
+// an error response is written and false is returned.
+func refreshTokenFromRequest(ctx *gin.Context) (string, bool) {
+	token, err := ctx.Cookie("refresh_token")
+	if err == nil && len(token) > 0 {
+		return token, true
+	}
+
+	var req request.RefreshTokenRequest
+	err = ctx.ShouldBindJSON(&req)
+	if err != nil {
+		util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+		return "", false
+	}
+
+	if len(req.RefreshToken) == 0 {
+		util.CreateError(ctx, http.StatusBadRequest, "missing refresh token")
+		return "", false
+	}
+
+	return req.RefreshToken, true
+}
+
+// setRefreshCookie attaches the refresh token cookie to the response.
+// A negative maxAge instructs the client to remove the cookie.
+func (ac *AccountController) setRefreshCookie(ctx *gin.Context, token string, maxAge int) {
+	var cookieDomain string
+	if ac.GlobalController.Config.Gin.Env == "debug" {
+		cookieDomain = ac.GlobalController.Config.Gin.SigningDomain
+	} else {
+		cookieDomain = ".localhost"
+	}
+
+	ctx.SetSameSite(http.SameSiteNoneMode)
+	ctx.SetCookie(
+		"refresh_token",
+		token,
+		maxAge,
+		"/",
+		cookieDomain,
+		true,
+		true,
+	)
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/response"
+	"tc-server/util"
+	"time"
+)
+
+const usernameHistoryCollectionName = "username_history"
+
+// ChangeUsername replaces the username of the requesting account. Changes
+// are limited per period and the previous username is held back from
+// other accounts for the configured cooldown, during which lookups of it
+// are redirected to the new username.
+func (ac *AccountController) ChangeUsername() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mongop := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: ac.CollectionName,
+		}
+		historyp := db.MongoParams{
+			Client:         ac.GlobalController.Mongo,
+			DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: usernameHistoryCollectionName,
+		}
+		conf := ac.GlobalController.Config
+
+		var req request.ChangeUsernameRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		if !util.ValidateUsername(req.Username) {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid username")
+			return
+		}
+
+		account, ok := ac.requestingAccount(ctx)
+		if !ok {
+			return
+		}
+
+		if req.Username == account.Username {
+			util.CreateError(ctx, http.StatusBadRequest, "username is unchanged")
+			return
+		}
+
+		now := time.Now()
+		limit, period := conf.Account.UsernameChangeLimit, conf.Account.UsernameChangePeriod
+		if wait := model.UsernameChangeWait(account.UsernameChangedAt, now, limit, period); wait > 0 {
+			respondUsernameChangeLimited(ctx, wait)
+			return
+		}
+
+		_, err = db.FindDocumentByKeyValue[string, model.Account](mongop, "username", req.Username)
+		if err == nil {
+			util.CreateError(ctx, http.StatusConflict, "username is in use")
+			return
+		}
+
+		if err != mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform duplicate username lookup: "+err.Error())
+			return
+		}
+
+		reserved, err := ac.identifierReservedFor(account.ID, model.ReservedUsername, req.Username)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if reserved {
+			util.CreateError(ctx, http.StatusConflict, "username is in use")
+			return
+		}
+
+		// The limit is enforced again by the update itself, so concurrent
+		// requests can never exceed it.
+		filter := bson.M{"_id": account.ID, "username": account.Username}
+		update := bson.M{"$set": bson.M{"username": req.Username}}
+		if limit > 0 {
+			filter["$expr"] = bson.M{"$lt": bson.A{bson.M{"$size": bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$username_changed_at", bson.A{}}},
+				"cond":  bson.M{"$gt": bson.A{"$$this", now.AddDate(0, 0, -period)}},
+			}}}, limit}}
+			update["$push"] = bson.M{"username_changed_at": bson.M{"$each": bson.A{now}, "$slice": -limit}}
+		}
+
+		result, err := db.UpdateDocumentMatching(mongop, filter, update)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to change username: "+err.Error())
+			return
+		}
+
+		if result.ModifiedCount == 0 {
+			current, err := db.FindDocumentById[model.Account](mongop, account.ID.Hex())
+			if err != nil {
+				util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+				return
+			}
+
+			if wait := model.UsernameChangeWait(current.UsernameChangedAt, now, limit, period); wait > 0 {
+				respondUsernameChangeLimited(ctx, wait)
+				return
+			}
+
+			util.CreateError(ctx, http.StatusConflict, "username was changed concurrently")
+			return
+		}
+
+		_, err = db.InsertDocument(historyp, model.UsernameChange{
+			AccountID:   account.ID,
+			OldUsername: account.Username,
+			NewUsername: req.Username,
+			ChangedAt:   now,
+		})
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record username change: "+err.Error())
+			return
+		}
+
+		expiresAt := now.AddDate(0, 0, conf.Account.UsernameCooldown)
+		if err := ac.reserveIdentifier(account.ID, model.ReservedUsername, account.Username, model.ReservedReasonUsernameChanged, expiresAt); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		entry := ac.GlobalController.newAuditEntry(ctx, account, "username", account.Username, req.Username)
+		if err := ac.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		account.Username = req.Username
+		ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
+	}
+}
+
+// respondUsernameChangeLimited responds with 429 and a Retry-After header
+// for the time until the username may be changed again.
+func respondUsernameChangeLimited(ctx *gin.Context, wait time.Duration) {
+	ctx.Header("Retry-After", fmt.Sprint(int(wait.Seconds())+1))
+	util.CreateError(ctx, http.StatusTooManyRequests, "username was changed too often")
+}
+
+// previousUsernameOwner returns the account that most recently gave up
+// the provided username. mongo.ErrNoDocuments is returned if the
+// username was never changed.
+func (ac *AccountController) previousUsernameOwner(username string) (model.Account, error) {
+	change, err := db.FindDocumentByFilter[model.UsernameChange](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: usernameHistoryCollectionName,
+	}, bson.M{"old_username": username}, options.FindOne().SetSort(bson.M{"changed_at": -1}))
+	if err != nil {
+		return model.Account{}, err
+	}
+
+	return db.FindDocumentById[model.Account](db.MongoParams{
+		Client:         ac.GlobalController.Mongo,
+		DBName:         ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: ac.CollectionName,
+	}, change.AccountID.Hex())
+}
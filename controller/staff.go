@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"slices"
+	"strings"
+	"tc-server/db"
+	"tc-server/middleware"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/response"
+	"tc-server/util"
+)
+
+type StaffController struct {
+	GlobalController *GlobalController
+	CollectionName   string
+}
+
+// ApplyStaffRoutes applies all staff routes to the provided gin instance.
+// Every staff route requires an access token carrying the staff role.
+func (c *GlobalController) ApplyStaffRoutes(router *gin.Engine) {
+	sc := StaffController{
+		GlobalController: c,
+		CollectionName:   "account",
+	}
+
+	limiter := c.Limiter
+
+	staff := router.Group("/v1/staff")
+	staff.Use(
+		middleware.Authorize(sc.GlobalController.AccessKeys, sc.GlobalController.Redis),
+		limiter.Limit("account"),
+		middleware.RequireRole(model.RoleStaff),
+	)
+	{
+		staff.GET("/account/:accountId/audit", middleware.RequireScope("staff:accounts"), sc.GetAccountAudit())           // Return the audit log of an account
+		staff.PUT("/account/:accountId/roles", middleware.RequireScope("staff:accounts"), sc.UpdateAccountRoles())        // Replace the roles of an account
+		staff.GET("/account/:accountId/usernames", middleware.RequireScope("staff:moderation"), sc.GetAccountUsernames()) // Return the username history of an account
+		staff.GET("/usernames/:username", middleware.RequireScope("staff:moderation"), sc.GetUsernameHistory())           // Return every account that held a username
+	}
+}
+
+// GetAccountAudit returns the audit log of the provided account, most
+// recent changes first.
+func (sc *StaffController) GetAccountAudit() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		accountId, err := primitive.ObjectIDFromHex(ctx.Param("accountId"))
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid account id")
+			return
+		}
+
+		entries, err := db.FindManyDocumentsByFilterWithOpts[model.AccountAuditEntry](db.MongoParams{
+			Client:         sc.GlobalController.Mongo,
+			DBName:         sc.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: auditCollectionName,
+		}, bson.M{"account_id": accountId}, options.Find().SetSort(bson.M{"created_at": -1}))
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform audit lookup: "+err.Error())
+			return
+		}
+
+		if entries == nil {
+			entries = []model.AccountAuditEntry{}
+		}
+
+		ctx.JSON(http.StatusOK, entries)
+	}
+}
+
+// GetAccountUsernames returns the username changes of the provided
+// account, most recent first.
+func (sc *StaffController) GetAccountUsernames() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		accountId, err := primitive.ObjectIDFromHex(ctx.Param("accountId"))
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid account id")
+			return
+		}
+
+		sc.respondUsernameHistory(ctx, bson.M{"account_id": accountId})
+	}
+}
+
+// GetUsernameHistory returns every change from or to the provided
+// username, most recent first, revealing which accounts held it.
+func (sc *StaffController) GetUsernameHistory() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		username := ctx.Param("username")
+		if !util.ValidateUsername(username) {
+			util.CreateError(ctx, http.StatusBadRequest, "invalid username")
+			return
+		}
+
+		sc.respondUsernameHistory(ctx, bson.M{"$or": bson.A{
+			bson.M{"old_username": username},
+			bson.M{"new_username": username},
+		}})
+	}
+}
+
+// respondUsernameHistory responds with the username changes matching the
+// provided filter.
+func (sc *StaffController) respondUsernameHistory(ctx *gin.Context, filter bson.M) {
+	changes, err := db.FindManyDocumentsByFilterWithOpts[model.UsernameChange](db.MongoParams{
+		Client:         sc.GlobalController.Mongo,
+		DBName:         sc.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: usernameHistoryCollectionName,
+	}, filter, options.Find().SetSort(bson.M{"changed_at": -1}))
+	if err != nil {
+		util.CreateError(ctx, http.StatusInternalServerError, "failed to perform username history lookup: "+err.Error())
+		return
+	}
+
+	if changes == nil {
+		changes = []model.UsernameChange{}
+	}
+
+	ctx.JSON(http.StatusOK, changes)
+}
+
+// UpdateAccountRoles replaces the roles of the provided account. Access
+// tokens issued with the previous roles are invalidated so the change
+// applies as soon as the account refreshes its tokens.
+func (sc *StaffController) UpdateAccountRoles() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: sc.GlobalController.Redis}
+		mongop := db.MongoParams{
+			Client:         sc.GlobalController.Mongo,
+			DBName:         sc.GlobalController.Config.Mongo.DatabaseName,
+			CollectionName: sc.CollectionName,
+		}
+
+		var req request.UpdateRolesRequest
+		err := ctx.ShouldBindJSON(&req)
+		if err != nil {
+			util.CreateError(ctx, http.StatusBadRequest, "unable to bind JSON: "+err.Error())
+			return
+		}
+
+		var roles []string
+		for _, role := range req.Roles {
+			if !model.ValidRole(role) {
+				util.CreateError(ctx, http.StatusBadRequest, "invalid role "+role)
+				return
+			}
+
+			if !slices.Contains(roles, role) {
+				roles = append(roles, role)
+			}
+		}
+
+		if !slices.Contains(roles, model.RoleMember) {
+			util.CreateError(ctx, http.StatusBadRequest, "every account must hold the member role")
+			return
+		}
+
+		account, err := db.FindDocumentById[model.Account](mongop, ctx.Param("accountId"))
+		if err == mongo.ErrNoDocuments {
+			util.CreateError(ctx, http.StatusNotFound, "account not found")
+			return
+		}
+
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to perform account lookup: "+err.Error())
+			return
+		}
+
+		previous := account.EffectiveRoles()
+		_, err = db.UpdateDocument(mongop, account.ID, "roles", roles)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to update roles: "+err.Error())
+			return
+		}
+
+		account.Roles = roles
+		entry := sc.GlobalController.newAuditEntry(ctx, account, "roles", strings.Join(previous, ","), strings.Join(roles, ","))
+		if err := sc.GlobalController.recordAudit(entry); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to record audit entry: "+err.Error())
+			return
+		}
+
+		if err := middleware.RevokeAccountRoles(redisp, account.ID.Hex()); err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to revoke access tokens: "+err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, response.NewAccountResponse(account))
+	}
+}
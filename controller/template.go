@@ -4,10 +4,32 @@ import (
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/mongo"
 	"tc-server/config"
+	"tc-server/mail"
+	"tc-server/middleware"
+	"tc-server/oidc"
+	"tc-server/storage"
+	"tc-server/util"
+	"tc-server/webauthn"
 )
 
 type GlobalController struct {
 	Config *config.FullConfig
 	Mongo  *mongo.Client
 	Redis  *redis.Client
+	Mail   *mail.Outbox
+	Blobs  storage.BlobStore
+
+	// Exports keeps data export archives apart from Blobs, which may be
+	// served publicly.
+	Exports storage.BlobStore
+	Limiter *middleware.RateLimiter
+
+	AccessKeys *util.Keyring
+	RefreshKey *util.TokenKey
+	MFAKey     *util.TokenKey
+	Secrets    *util.SecretBox
+	Passwords  *util.PasswordHasher
+	Policy     *util.PasswordPolicy
+	WebAuthn   *webauthn.RelyingParty
+	OIDC       map[string]*oidc.Provider
 }
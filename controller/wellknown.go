@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// ApplyWellKnownRoutes applies all /.well-known routes to the provided
+// gin instance.
+func (c *GlobalController) ApplyWellKnownRoutes(router *gin.Engine) {
+	pub := router.Group("/.well-known")
+	{
+		pub.GET("/jwks.json", c.GetJWKS()) // Return the public keys access tokens are signed with
+	}
+}
+
+// GetJWKS publishes the public access token keys as a JSON Web Key Set,
+// allowing other services to verify access tokens without holding the
+// signing key. Retired keys are published until their retirement window
+// has passed. HMAC keys are never published.
+func (c *GlobalController) GetJWKS() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		keys := make([]map[string]string, 0)
+		for _, key := range c.AccessKeys.VerificationKeys() {
+			if jwk, ok := key.JWK(); ok {
+				keys = append(keys, jwk)
+			}
+		}
+
+		ctx.Header("Cache-Control", "public, max-age=300")
+		ctx.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -56,13 +57,14 @@ func FindDocumentByKeyValue[K any, V any](
 func FindDocumentByFilter[K any](
 	params MongoParams,
 	filter bson.M,
+	opts ...*options.FindOneOptions,
 ) (K, error) {
 	ctx, cancel := GetMongoContext()
 	collection := params.Client.Database(params.DBName).Collection(params.CollectionName)
 	defer cancel()
 
 	var document K
-	err := collection.FindOne(ctx, filter).Decode(&document)
+	err := collection.FindOne(ctx, filter, opts...).Decode(&document)
 	return document, err
 }
 
@@ -91,6 +93,10 @@ func FindManyDocumentsByFilter[K any](
 
 	var documents []K
 	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return documents, err
+	}
+
 	err = cursor.All(ctx, &documents)
 	return documents, err
 }
@@ -106,6 +112,10 @@ func FindManyDocumentsByFilterWithOpts[K any](
 
 	var documents []K
 	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return documents, err
+	}
+
 	err = cursor.All(ctx, &documents)
 	return documents, err
 }
@@ -119,8 +129,15 @@ func InsertDocument[K any](
 	defer cancel()
 
 	result, err := collection.InsertOne(ctx, document)
-	id := result.InsertedID.(primitive.ObjectID).Hex()
-	return id, err
+	if err != nil {
+		return "", err
+	}
+
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return id.Hex(), nil
+	}
+
+	return fmt.Sprint(result.InsertedID), nil
 }
 
 func ReplaceDocument[K any](
@@ -144,7 +161,7 @@ func UpdateDocument[K any](
 	collection := params.Client.Database(params.DBName).Collection(params.CollectionName)
 	defer cancel()
 
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": documentId}, bson.D{{"$set", bson.D{{key, value}}}})
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": documentId}, bson.D{{Key: "$set", Value: bson.D{{Key: key, Value: value}}}})
 	return result, err
 }
 
@@ -160,6 +177,39 @@ func UpdateDocumentByFilter[K any](
 	return result, err
 }
 
+// UpdateDocumentMatching applies the update to the first document
+// matching the filter, allowing positional updates of array elements.
+func UpdateDocumentMatching(
+	params MongoParams,
+	filter interface{},
+	update interface{}) (*mongo.UpdateResult, error) {
+	ctx, cancel := GetMongoContext()
+	collection := params.Client.Database(params.DBName).Collection(params.CollectionName)
+	defer cancel()
+
+	result, err := collection.UpdateOne(ctx, filter, update)
+	return result, err
+}
+
+// FindOneAndUpdateDocument atomically applies the update to the first
+// document matching the filter and returns the updated document.
+func FindOneAndUpdateDocument[K any](
+	params MongoParams,
+	filter interface{},
+	update interface{},
+	opts ...*options.FindOneAndUpdateOptions,
+) (K, error) {
+	ctx, cancel := GetMongoContext()
+	collection := params.Client.Database(params.DBName).Collection(params.CollectionName)
+	defer cancel()
+
+	opts = append(opts, options.FindOneAndUpdate().SetReturnDocument(options.After))
+
+	var document K
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts...).Decode(&document)
+	return document, err
+}
+
 func DeleteDocument[K any](
 	params MongoParams,
 	document K,
@@ -171,3 +221,15 @@ func DeleteDocument[K any](
 	result, err := collection.DeleteOne(ctx, document)
 	return result, err
 }
+
+func DeleteManyDocuments(
+	params MongoParams,
+	filter interface{},
+) (*mongo.DeleteResult, error) {
+	ctx, cancel := GetMongoContext()
+	collection := params.Client.Database(params.DBName).Collection(params.CollectionName)
+	defer cancel()
+
+	result, err := collection.DeleteMany(ctx, filter)
+	return result, err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/redis/go-redis/v9"
+	"strconv"
 	"tc-server/config"
 	"time"
 )
@@ -78,3 +79,218 @@ func DeleteCacheValue(params RedisParams, key string) (int64, error) {
 
 	return result.Result()
 }
+
+// GetAndDeleteCacheValue atomically reads and removes a cache value,
+// guaranteeing only a single caller can ever consume the entry.
+func GetAndDeleteCacheValue(params RedisParams, key string) (string, error) {
+	if params.RedisClient == nil {
+		return "", fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	result := params.RedisClient.GetDel(ctx, key)
+	if result.Err() != nil {
+		return "", result.Err()
+	}
+
+	return result.Result()
+}
+
+// IncrementCacheValue increments the integer stored at key by one,
+// initializing missing keys to zero before the operation.
+func IncrementCacheValue(params RedisParams, key string) (int64, error) {
+	if params.RedisClient == nil {
+		return -1, fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	result := params.RedisClient.Incr(ctx, key)
+	if result.Err() != nil {
+		return -1, result.Err()
+	}
+
+	return result.Result()
+}
+
+// SetCacheValueIfAbsent sets the provided value only if the key does
+// not exist yet. The returned bool reports whether the value was set.
+func SetCacheValueIfAbsent[K any](
+	params RedisParams,
+	key string,
+	value K,
+	ttl int,
+) (bool, error) {
+	if params.RedisClient == nil {
+		return false, fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	result := params.RedisClient.SetNX(ctx, key, value, time.Duration(ttl)*time.Second)
+	if result.Err() != nil {
+		return false, result.Err()
+	}
+
+	return result.Result()
+}
+
+// IncrementCacheCounter increments the integer stored at key by one. The
+// key expires ttl seconds after the first increment, making the counter
+// count within a fixed window.
+func IncrementCacheCounter(params RedisParams, key string, ttl int) (int64, error) {
+	if params.RedisClient == nil {
+		return -1, fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	pipe := params.RedisClient.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, time.Duration(ttl)*time.Second)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return -1, err
+	}
+
+	return incr.Result()
+}
+
+// GetCacheTTL returns the remaining time to live of a key. Missing keys
+// and keys without expiry return a negative duration.
+func GetCacheTTL(params RedisParams, key string) (time.Duration, error) {
+	if params.RedisClient == nil {
+		return -1, fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	result := params.RedisClient.TTL(ctx, key)
+	if result.Err() != nil {
+		return -1, result.Err()
+	}
+
+	return result.Result()
+}
+
+// slidingWindowScript atomically drops hits older than the window from
+// the sorted set at KEYS[1] and records a new hit if fewer than the limit
+// remain. It returns whether the hit was recorded, the number of hits in
+// the window and the score of the oldest hit.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], 0, now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < tonumber(ARGV[3]) then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {allowed, count, oldest[2] or ARGV[1]}
+`)
+
+// RecordSlidingWindowHit records a hit in the sliding window log at key
+// unless the limit has been reached within the window. It returns whether
+// the hit was recorded, the number of hits within the window and the time
+// of the oldest hit.
+func RecordSlidingWindowHit(params RedisParams, key string, limit int, window time.Duration, member string) (bool, int64, time.Time, error) {
+	if params.RedisClient == nil {
+		return false, -1, time.Time{}, fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	now := time.Now().UnixMilli()
+	result, err := slidingWindowScript.Run(ctx, params.RedisClient, []string{key}, now, window.Milliseconds(), limit, member).Slice()
+	if err != nil {
+		return false, -1, time.Time{}, err
+	}
+
+	if len(result) != 3 {
+		return false, -1, time.Time{}, fmt.Errorf("unexpected sliding window result %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	count, _ := result[1].(int64)
+	oldest, err := strconv.ParseInt(fmt.Sprint(result[2]), 10, 64)
+	if err != nil {
+		return false, -1, time.Time{}, fmt.Errorf("invalid sliding window score: %w", err)
+	}
+
+	return allowed == 1, count, time.UnixMilli(oldest), nil
+}
+
+// AddCacheSetMember adds a member to the set at key and resets the
+// expiry of the set to ttl seconds.
+func AddCacheSetMember(params RedisParams, key string, member string, ttl int) error {
+	if params.RedisClient == nil {
+		return fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	pipe := params.RedisClient.TxPipeline()
+	pipe.SAdd(ctx, key, member)
+	pipe.Expire(ctx, key, time.Duration(ttl)*time.Second)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetCacheSetMembers returns every member of the set at key.
+func GetCacheSetMembers(params RedisParams, key string) ([]string, error) {
+	if params.RedisClient == nil {
+		return nil, fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	return params.RedisClient.SMembers(ctx, key).Result()
+}
+
+// RemoveCacheSetMember removes a member from the set at key.
+func RemoveCacheSetMember(params RedisParams, key string, member string) error {
+	if params.RedisClient == nil {
+		return fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	return params.RedisClient.SRem(ctx, key, member).Err()
+}
+
+// DeleteCacheSetWithMembers removes the set at key together with the
+// cache value of every member, which is stored at prefix followed by
+// the member.
+func DeleteCacheSetWithMembers(params RedisParams, key string, prefix string) error {
+	if params.RedisClient == nil {
+		return fmt.Errorf("redis client is nil")
+	}
+
+	ctx, cancel := GetRedisContext()
+	defer cancel()
+
+	members, err := params.RedisClient.SMembers(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{key}
+	for _, member := range members {
+		keys = append(keys, prefix+member)
+	}
+
+	return params.RedisClient.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"tc-server/util"
+	"time"
+)
+
+// FileMailer writes messages to disk instead of delivering them, which
+// allows mail flows to be exercised locally without an SMTP server. When
+// no directory is configured messages are written to stdout.
+type FileMailer struct {
+	Directory string
+	From      string
+	mu        sync.Mutex
+}
+
+// Send writes the message to a new .eml file within the configured
+// directory, or to stdout if no directory is set.
+func (m *FileMailer) Send(msg Message) error {
+	b, err := msg.Bytes(m.From)
+	if err != nil {
+		return err
+	}
+
+	if len(m.Directory) == 0 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		_, err = os.Stdout.Write(append(b, '\n'))
+		return err
+	}
+
+	if err := os.MkdirAll(m.Directory, 0o755); err != nil {
+		return err
+	}
+
+	suffix, err := util.GenerateRandomString(4)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), suffix)
+	return os.WriteFile(filepath.Join(m.Directory, name), b, 0o644)
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"fmt"
+	"tc-server/config"
+)
+
+// Message is a single outbound email. HTML is optional, when present
+// the message is sent as multipart/alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers outbound messages. Implementations must be safe
+// for concurrent use.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New returns the Mailer selected by the driver of the provided
+// mail configuration.
+func New(conf *config.MailConfig) (Mailer, error) {
+	switch conf.Driver {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     conf.SMTP.Host,
+			Port:     conf.SMTP.Port,
+			Username: conf.SMTP.Username,
+			Password: conf.SMTP.Password,
+			From:     conf.From,
+		}, nil
+	case "file", "":
+		return &FileMailer{Directory: conf.Directory, From: conf.From}, nil
+	case "memory":
+		return &MemoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", conf.Driver)
+	}
+}
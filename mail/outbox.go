@@ -0,0 +1,192 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math"
+	"sync"
+	"tc-server/config"
+	"tc-server/db"
+	"tc-server/model"
+	"time"
+)
+
+// sendTimeout bounds how long a claimed message stays locked to a
+// worker before another worker is allowed to retry it.
+const sendTimeout = 2 * time.Minute
+
+// Outbox queues outbound messages in Mongo and delivers them from a
+// background worker. Queueing never fails because of a transient Mongo
+// error, messages that could not be stored are buffered in memory and
+// stored again on the next poll.
+type Outbox struct {
+	Mailer      Mailer
+	Params      db.MongoParams
+	Interval    time.Duration
+	MaxAttempts int
+
+	mu       sync.Mutex
+	buffered []model.OutboxMessage
+}
+
+// NewOutbox creates an outbox delivering through the provided mailer
+// using the outbox settings of the mail configuration.
+func NewOutbox(mailer Mailer, params db.MongoParams, conf *config.OutboxConfig) *Outbox {
+	interval := time.Duration(conf.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	attempts := conf.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	return &Outbox{
+		Mailer:      mailer,
+		Params:      params,
+		Interval:    interval,
+		MaxAttempts: attempts,
+	}
+}
+
+// Enqueue renders the named template and queues the resulting message
+// for delivery to the provided address. Only rendering errors are
+// returned since a failed insert is retried by the worker.
+func (o *Outbox) Enqueue(to string, template string, data any) error {
+	msg, err := Render(template, to, data)
+	if err != nil {
+		return fmt.Errorf("failed to render %s mail: %w", template, err)
+	}
+
+	now := time.Now()
+	entry := model.OutboxMessage{
+		To:            msg.To,
+		Subject:       msg.Subject,
+		Text:          msg.Text,
+		HTML:          msg.HTML,
+		Status:        model.OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+
+	if _, err := db.InsertDocument(o.Params, entry); err != nil {
+		fmt.Println("Failed to store outbox message, buffering: " + err.Error())
+		o.mu.Lock()
+		o.buffered = append(o.buffered, entry)
+		o.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Buffered reports how many messages are waiting in memory to be stored.
+func (o *Outbox) Buffered() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.buffered)
+}
+
+// Run polls the outbox until the context is cancelled, delivering every
+// message that is due.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		o.flushBuffered()
+		o.drain()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushBuffered stores messages that previously failed to be inserted.
+func (o *Outbox) flushBuffered() {
+	o.mu.Lock()
+	buffered := o.buffered
+	o.buffered = nil
+	o.mu.Unlock()
+
+	for i, entry := range buffered {
+		if _, err := db.InsertDocument(o.Params, entry); err != nil {
+			o.mu.Lock()
+			o.buffered = append(o.buffered, buffered[i:]...)
+			o.mu.Unlock()
+			return
+		}
+	}
+}
+
+// drain delivers due messages until none are left.
+func (o *Outbox) drain() {
+	for {
+		msg, err := o.claim()
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+
+		if err != nil {
+			fmt.Println("Failed to claim outbox message: " + err.Error())
+			return
+		}
+
+		o.deliver(msg)
+	}
+}
+
+// claim locks the next due message to this worker. Messages locked by a
+// worker that did not finish within sendTimeout are claimed again.
+func (o *Outbox) claim() (model.OutboxMessage, error) {
+	now := time.Now()
+
+	return db.FindOneAndUpdateDocument[model.OutboxMessage](o.Params, bson.M{
+		"$or": bson.A{
+			bson.M{"status": model.OutboxStatusPending, "next_attempt_at": bson.M{"$lte": now}},
+			bson.M{"status": model.OutboxStatusSending, "locked_until": bson.M{"$lte": now}},
+		},
+	}, bson.M{
+		"$set": bson.M{"status": model.OutboxStatusSending, "locked_until": now.Add(sendTimeout)},
+		"$inc": bson.M{"attempts": 1},
+	})
+}
+
+// deliver sends a claimed message and records the outcome. Failed
+// deliveries are retried with exponential backoff until MaxAttempts.
+func (o *Outbox) deliver(msg model.OutboxMessage) {
+	err := o.Mailer.Send(Message{
+		To:      msg.To,
+		Subject: msg.Subject,
+		Text:    msg.Text,
+		HTML:    msg.HTML,
+	})
+
+	// Bodies carry single use links, they are dropped as soon as the
+	// message can no longer be delivered again.
+	finished := bson.M{"text": "", "html": ""}
+
+	update := bson.M{"$set": bson.M{"sent_at": time.Now(), "status": model.OutboxStatusSent}, "$unset": finished}
+	if err != nil {
+		set := bson.M{"last_error": err.Error(), "status": model.OutboxStatusPending}
+		update = bson.M{"$set": set}
+		if msg.Attempts >= o.MaxAttempts {
+			set["status"] = model.OutboxStatusFailed
+			update["$unset"] = finished
+		} else {
+			backoff := o.Interval * time.Duration(math.Pow(2, float64(msg.Attempts-1)))
+			set["next_attempt_at"] = time.Now().Add(backoff)
+		}
+	}
+
+	_, err = db.UpdateDocumentByFilter[model.OutboxMessage](o.Params, msg.ID, update)
+	if err != nil {
+		fmt.Println("Failed to update outbox message: " + err.Error())
+	}
+}
@@ -3,50 +3,98 @@ package middleware
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/redis/go-redis/v9"
 	"net/http"
+	"slices"
 	"strconv"
-	"tc-server/config"
+	"tc-server/db"
+	"tc-server/model"
 	"tc-server/util"
+	"time"
 )
 
 // Authorize parses and validates an auth token
-// in the form of middleware. If the token is invalid
-// or expired the request will be denied.
-func Authorize(conf *config.FullConfig) gin.HandlerFunc {
+// in the form of middleware. If the token is invalid,
+// expired or revoked the request will be denied.
+func Authorize(keys util.KeySet, rdb *redis.Client) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		const BearerSchema = "Bearer "
-
 		header := ctx.GetHeader("Authorization")
 		if len(header) < 7 {
 			ctx.AbortWithStatus(http.StatusBadRequest)
 			return
 		}
 
-		var tokenAsString string
-		var err error
-		tokenAsString = header[len(BearerSchema):]
-
-		if string(tokenAsString[0]) == `"` {
-			tokenAsString, err = strconv.Unquote(tokenAsString)
+		tokenAsString, err := BearerToken(ctx)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
 		}
 
+		claims, err := util.ValidateTokenClaims(tokenAsString, keys)
 		if err != nil {
 			ctx.AbortWithStatus(http.StatusUnauthorized)
+			fmt.Println("Failed to validate token: " + err.Error())
 			return
 		}
 
-		token, err := util.ValidateToken(tokenAsString, conf.Auth.AccessTokenPub)
-		if err != nil || !token.Valid {
+		if slices.Contains(claims.Scopes, model.ScopeMFAPending) {
 			ctx.AbortWithStatus(http.StatusUnauthorized)
-			fmt.Println("Failed to validate token: " + err.Error())
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		id := claims["accountId"].(string)
+		redisp := db.RedisParams{RedisClient: rdb}
+
+		revoked, err := IsTokenRevoked(redisp, claims.ID)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			fmt.Println("Failed to query token revocation: " + err.Error())
+			return
+		}
+
+		generation, err := GetTokenGeneration(redisp, claims.AccountID)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			fmt.Println("Failed to query token generation: " + err.Error())
+			return
+		}
+
+		roleGeneration, err := GetRoleGeneration(redisp, claims.AccountID)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusInternalServerError)
+			fmt.Println("Failed to query role generation: " + err.Error())
+			return
+		}
+
+		if revoked || claims.Generation < generation || claims.RoleGeneration < roleGeneration {
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 
-		ctx.Set("accountId", id)
+		ctx.Set("accountId", claims.AccountID)
+		ctx.Set("roles", claims.Roles)
+		ctx.Set("scopes", claims.Scopes)
+		ctx.Set("tokenId", claims.ID)
+		ctx.Set("sessionId", claims.FamilyID)
+		ctx.Set("tokenExpiresAt", claims.ExpiresAt.Time)
+		ctx.Set("authTime", time.Unix(claims.AuthTime, 0))
 		ctx.Next()
 	}
 }
+
+// BearerToken extracts the raw token from the Authorization header
+// of the request, unquoting it if required.
+func BearerToken(ctx *gin.Context) (string, error) {
+	const BearerSchema = "Bearer "
+
+	header := ctx.GetHeader("Authorization")
+	if len(header) <= len(BearerSchema) {
+		return "", fmt.Errorf("missing bearer token")
+	}
+
+	tokenAsString := header[len(BearerSchema):]
+	if string(tokenAsString[0]) == `"` {
+		return strconv.Unquote(tokenAsString)
+	}
+
+	return tokenAsString, nil
+}
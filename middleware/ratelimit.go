@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"net/http"
+	"strconv"
+	"tc-server/config"
+	"tc-server/db"
+	"tc-server/util"
+	"time"
+)
+
+// rateLimitPrefix prefixes the cache key holding the sliding window log
+// of a client for a policy.
+const rateLimitPrefix = "rate_limit:"
+
+// RateLimiter enforces the named rate limit policies of the configuration
+// using a sliding window log stored in cache, so limits hold across
+// multiple server replicas.
+type RateLimiter struct {
+	Redis    *redis.Client
+	Policies map[string]config.RateLimitPolicy
+}
+
+// NewRateLimiter creates a rate limiter for the configured policies.
+// Policies keyed by anything but "ip" or "account" are rejected, since
+// they would silently fall back to the client IP address.
+func NewRateLimiter(rdb *redis.Client, conf *config.RateLimitConfig) (*RateLimiter, error) {
+	for name, policy := range conf.Policies {
+		if policy.Key != "ip" && policy.Key != "account" {
+			return nil, fmt.Errorf("policy %q has unknown key %q", name, policy.Key)
+		}
+	}
+
+	return &RateLimiter{Redis: rdb, Policies: conf.Policies}, nil
+}
+
+// Limit returns middleware enforcing the named policy and reporting it
+// through X-RateLimit-* headers. Policies keyed by account must be used
+// after Authorize. Requests pass unlimited if the policy is not
+// configured.
+func (l *RateLimiter) Limit(name string) gin.HandlerFunc {
+	policy, ok := l.Policies[name]
+	if !ok || policy.Limit <= 0 || policy.Window <= 0 {
+		return func(ctx *gin.Context) {
+			ctx.Next()
+		}
+	}
+
+	window := time.Duration(policy.Window) * time.Second
+	return func(ctx *gin.Context) {
+		redisp := db.RedisParams{RedisClient: l.Redis}
+
+		member, err := util.GenerateRandomString(8)
+		if err != nil {
+			util.CreateError(ctx, http.StatusInternalServerError, "failed to apply rate limit: "+err.Error())
+			return
+		}
+
+		key := rateLimitPrefix + name + ":" + RateLimitClient(ctx, policy.Key)
+		allowed, count, oldest, err := db.RecordSlidingWindowHit(redisp, key, policy.Limit, window, member)
+		if err != nil {
+			// An unavailable cache must not take every route down with it.
+			fmt.Println("Failed to apply rate limit: " + err.Error())
+			ctx.Next()
+			return
+		}
+
+		reset := strconv.Itoa(int(math.Ceil(time.Until(oldest.Add(window)).Seconds())))
+		ctx.Header("X-RateLimit-Limit", strconv.Itoa(policy.Limit))
+		ctx.Header("X-RateLimit-Remaining", strconv.Itoa(max(policy.Limit-int(count), 0)))
+		ctx.Header("X-RateLimit-Reset", reset)
+
+		if !allowed {
+			ctx.Header("Retry-After", reset)
+			util.CreateError(ctx, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// RateLimitClient identifies the client of a request for the provided
+// policy key. Requests without an account fall back to the client IP
+// address.
+func RateLimitClient(ctx *gin.Context, key string) string {
+	switch key {
+	case "account":
+		if id := ctx.GetString("accountId"); len(id) > 0 {
+			return "account:" + id
+		}
+	}
+
+	return "ip:" + ctx.ClientIP()
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"tc-server/db"
+)
+
+const (
+	// tokenGenerationPrefix prefixes the cache key holding the token
+	// generation of an account. Tokens carry the generation they were
+	// issued under and are rejected once the account generation moves
+	// past it.
+	tokenGenerationPrefix = "token_generation:"
+
+	// roleGenerationPrefix prefixes the cache key holding the role
+	// generation of an account. Access tokens carrying an older role
+	// generation were issued with outdated roles and are rejected, while
+	// refresh tokens stay valid so clients can fetch updated roles.
+	roleGenerationPrefix = "role_generation:"
+
+	// revokedTokenPrefix prefixes the cache keys of individually revoked
+	// access tokens, keyed by their jti claim.
+	revokedTokenPrefix = "revoked_token:"
+)
+
+// GetTokenGeneration returns the current token generation for the
+// provided account. Accounts that never revoked their tokens are on
+// generation zero.
+func GetTokenGeneration(params db.RedisParams, accountId string) (int64, error) {
+	value, err := db.GetCacheValue(params, tokenGenerationPrefix+accountId)
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// RevokeAccountTokens advances the token generation of an account,
+// invalidating every access and refresh token issued before the call.
+func RevokeAccountTokens(params db.RedisParams, accountId string) error {
+	_, err := db.IncrementCacheValue(params, tokenGenerationPrefix+accountId)
+	return err
+}
+
+// GetRoleGeneration returns the current role generation for the
+// provided account.
+func GetRoleGeneration(params db.RedisParams, accountId string) (int64, error) {
+	value, err := db.GetCacheValue(params, roleGenerationPrefix+accountId)
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// RevokeAccountRoles advances the role generation of an account,
+// invalidating every access token carrying the previous roles.
+func RevokeAccountRoles(params db.RedisParams, accountId string) error {
+	_, err := db.IncrementCacheValue(params, roleGenerationPrefix+accountId)
+	return err
+}
+
+// RevokeToken adds a single token ID to the revocation list. The entry
+// only needs to outlive the token itself, so ttl should match the
+// remaining token lifetime in seconds.
+func RevokeToken(params db.RedisParams, tokenId string, ttl int) error {
+	_, err := db.SetCacheValue(params, revokedTokenPrefix+tokenId, 1, ttl)
+	return err
+}
+
+// IsTokenRevoked returns true if the provided token ID has been
+// individually revoked.
+func IsTokenRevoked(params db.RedisParams, tokenId string) (bool, error) {
+	_, err := db.GetCacheValue(params, revokedTokenPrefix+tokenId)
+	if err == redis.Nil {
+		return false, nil
+	}
+
+	return err == nil, err
+}
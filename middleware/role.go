@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"slices"
+)
+
+// RequireRole only allows requests whose access token carries at least
+// one of the provided roles. It must be used after Authorize.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		granted := ctx.GetStringSlice("roles")
+		for _, role := range roles {
+			if slices.Contains(granted, role) {
+				ctx.Next()
+				return
+			}
+		}
+
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "insufficient role"})
+	}
+}
+
+// RequireScope only allows requests whose access token carries every
+// one of the provided scopes. It must be used after Authorize.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		granted := ctx.GetStringSlice("scopes")
+		for _, scope := range scopes {
+			if !slices.Contains(granted, scope) {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "missing scope " + scope})
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
@@ -8,12 +8,14 @@ import (
 type AccountConfirmable struct {
 	Value       string    `json:"value" bson:"value"`
 	Confirmed   bool      `json:"confirmed" bson:"confirmed"`
-	ConfirmedAt time.Time `json:"confirmed_at" bson:"confirmed_at"`
+	ConfirmedAt time.Time `json:"confirmed_at" bson:"confirmed_at,omitempty"`
 }
 
 type AccountProfile struct {
-	Avatar      string `json:"avatar,omitempty" bson:"avatar,omitempty"`
-	DisplayName string `json:"display_name,omitempty" bson:"display_name,omitempty"`
+	Avatar      string            `json:"avatar,omitempty" bson:"avatar,omitempty"`
+	Avatars     map[string]string `json:"avatars,omitempty" bson:"avatars,omitempty"` // Thumbnail URLs keyed by edge length
+	AvatarKeys  []string          `json:"-" bson:"avatar_keys,omitempty"`             // Blob keys of uploaded avatar renditions
+	DisplayName string            `json:"display_name,omitempty" bson:"display_name,omitempty"`
 }
 
 type AccountMetadata struct {
@@ -23,9 +25,55 @@ type AccountMetadata struct {
 }
 
 type Account struct {
-	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Username string             `json:"username" bson:"username"`
-	Email    AccountConfirmable `json:"email,omitempty" bson:"email,omitempty"`
-	Password string             `json:"password,omitempty" bson:"password,omitempty"`
-	Metadata AccountMetadata    `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	ID           primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Username     string               `json:"username" bson:"username"`
+	Email        AccountConfirmable   `json:"email,omitempty" bson:"email,omitempty"`
+	PendingEmail *AccountPendingEmail `json:"-" bson:"pending_email,omitempty"`
+	Password     string               `json:"password,omitempty" bson:"password,omitempty"`
+	Roles        []string             `json:"roles,omitempty" bson:"roles,omitempty"`
+	MFA          AccountMFA           `json:"-" bson:"mfa,omitempty"`
+	Passkeys     []Passkey            `json:"-" bson:"passkeys,omitempty"`
+	Identities   []AccountIdentity    `json:"-" bson:"identities,omitempty"`
+	Deletion     *AccountDeletion     `json:"-" bson:"deletion,omitempty"`
+	Metadata     AccountMetadata      `json:"metadata,omitempty" bson:"metadata,omitempty"`
+
+	// UsernameChangedAt holds the times of the most recent username
+	// changes, as many as the change limit allows per period.
+	UsernameChangedAt []time.Time `json:"-" bson:"username_changed_at,omitempty"`
+}
+
+// AccountPendingEmail is an email address change awaiting confirmation
+// by the new address. The current address stays in use until then.
+type AccountPendingEmail struct {
+	Value       string    `bson:"value"`
+	RequestedAt time.Time `bson:"requested_at"`
+}
+
+// AccountDeletion marks an account as pending deletion. The account is
+// purged once ScheduledAt has passed unless the deletion is cancelled
+// before the purge starts.
+type AccountDeletion struct {
+	RequestedAt    time.Time `bson:"requested_at"`
+	ScheduledAt    time.Time `bson:"scheduled_at"`
+	PurgeStartedAt time.Time `bson:"purge_started_at,omitempty"`
+}
+
+// EffectiveRoles returns the roles of the account. Accounts created
+// before roles were introduced are members.
+func (a Account) EffectiveRoles() []string {
+	if len(a.Roles) == 0 {
+		return []string{RoleMember}
+	}
+
+	return a.Roles
+}
+
+// AccountMFA holds the second factor settings of an account. Secrets are
+// encrypted and recovery codes are stored as SHA-256 hashes.
+type AccountMFA struct {
+	Enabled       bool      `bson:"enabled"`
+	Secret        string    `bson:"secret,omitempty"`
+	PendingSecret string    `bson:"pending_secret,omitempty"`
+	RecoveryCodes []string  `bson:"recovery_codes,omitempty"`
+	EnabledAt     time.Time `bson:"enabled_at,omitempty"`
 }
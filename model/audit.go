@@ -0,0 +1,20 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// AccountAuditEntry records a single change made to an account field,
+// including who made the change and from where.
+type AccountAuditEntry struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AccountID primitive.ObjectID `json:"account_id" bson:"account_id"`
+	ActorID   primitive.ObjectID `json:"actor_id" bson:"actor_id"`
+	Field     string             `json:"field" bson:"field"`
+	OldValue  string             `json:"old_value" bson:"old_value"`
+	NewValue  string             `json:"new_value" bson:"new_value"`
+	IP        string             `json:"ip" bson:"ip"`
+	UserAgent string             `json:"user_agent" bson:"user_agent"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
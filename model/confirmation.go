@@ -0,0 +1,18 @@
+package model
+
+// Fields a Confirmation can confirm. An email change is confirmed by the
+// new address and can be reverted through the old address.
+const (
+	ConfirmEmail       = "email"
+	ConfirmEmailChange = "email_change"
+	ConfirmEmailRevert = "email_revert"
+)
+
+// Confirmation is a pending confirmation of an account field. It is
+// stored in cache under a random confirmation ID which is sent to the
+// account owner.
+type Confirmation struct {
+	AccountID string `json:"account_id"`
+	Field     string `json:"field"`
+	Value     string `json:"value"`
+}
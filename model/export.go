@@ -0,0 +1,30 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	ExportStatusPending    = "pending"
+	ExportStatusProcessing = "processing"
+	ExportStatusReady      = "ready"
+	ExportStatusFailed     = "failed"
+)
+
+// DataExport is a request for an archive of all data stored about an
+// account. Once ready the archive is stored under BlobKey until
+// ExpiresAt.
+type DataExport struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	AccountID   primitive.ObjectID `bson:"account_id"`
+	Status      string             `bson:"status"`
+	Attempts    int                `bson:"attempts"`
+	LockedUntil time.Time          `bson:"locked_until,omitempty"`
+	LastError   string             `bson:"last_error,omitempty"`
+	BlobKey     string             `bson:"blob_key,omitempty"`
+	Size        int64              `bson:"size,omitempty"`
+	RequestedAt time.Time          `bson:"requested_at"`
+	CompletedAt time.Time          `bson:"completed_at,omitempty"`
+	ExpiresAt   time.Time          `bson:"expires_at,omitempty"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// AccountIdentity links an account to the subject of an external OpenID
+// Connect provider.
+type AccountIdentity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"-" bson:"subject"`
+	Email    string    `json:"email,omitempty" bson:"email,omitempty"`
+	LinkedAt time.Time `json:"linked_at" bson:"linked_at"`
+}
+
+// OIDCState is a pending OpenID Connect authorization stored in cache
+// under its state parameter. The account ID is only set when linking a
+// provider to an existing account.
+type OIDCState struct {
+	Provider  string `json:"provider"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	AccountID string `json:"account_id,omitempty"`
+}
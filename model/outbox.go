@@ -0,0 +1,28 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSending = "sending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusFailed  = "failed"
+)
+
+type OutboxMessage struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	To            string             `json:"to" bson:"to"`
+	Subject       string             `json:"subject" bson:"subject"`
+	Text          string             `json:"text,omitempty" bson:"text,omitempty"`
+	HTML          string             `json:"html,omitempty" bson:"html,omitempty"`
+	Status        string             `json:"status" bson:"status"`
+	Attempts      int                `json:"attempts" bson:"attempts"`
+	LastError     string             `json:"last_error,omitempty" bson:"last_error,omitempty"`
+	NextAttemptAt time.Time          `json:"next_attempt_at" bson:"next_attempt_at"`
+	LockedUntil   time.Time          `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+	SentAt        time.Time          `json:"sent_at,omitempty" bson:"sent_at,omitempty"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// Passkey is a WebAuthn credential registered to an account. The ID is
+// the base64url encoded credential ID.
+type Passkey struct {
+	ID         string    `json:"id" bson:"id"`
+	Name       string    `json:"name" bson:"name"`
+	PublicKey  []byte    `json:"-" bson:"public_key"`
+	SignCount  uint32    `json:"-" bson:"sign_count"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+}
+
+// PasskeyChallenge is a pending WebAuthn ceremony stored in cache. The
+// account ID is empty for logins using a discoverable credential.
+type PasskeyChallenge struct {
+	Challenge string `json:"challenge"`
+	AccountID string `json:"account_id,omitempty"`
+}
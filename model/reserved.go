@@ -0,0 +1,28 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	ReservedUsername = "username"
+	ReservedEmail    = "email"
+
+	ReservedReasonDeleted         = "account_deleted"
+	ReservedReasonEmailChanged    = "email_changed"
+	ReservedReasonUsernameChanged = "username_changed"
+)
+
+// ReservedIdentifier holds back a username or email address from being
+// used by another account until ExpiresAt. The account the value was
+// taken from may still use it.
+type ReservedIdentifier struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AccountID primitive.ObjectID `json:"account_id" bson:"account_id"`
+	Kind      string             `json:"kind" bson:"kind"`
+	Value     string             `json:"value" bson:"value"`
+	Reason    string             `json:"reason" bson:"reason"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+}
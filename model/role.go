@@ -0,0 +1,43 @@
+package model
+
+const (
+	RoleMember    = "member"
+	RoleCoach     = "coach"
+	RoleClubAdmin = "club_admin"
+	RoleStaff     = "staff"
+)
+
+// ScopeMFAPending is the only scope of the token issued after a correct
+// password for an account with a second factor. It is rejected by every
+// route except the second factor exchange.
+const ScopeMFAPending = "mfa:pending"
+
+// RoleScopes maps every role to the scopes it grants.
+var RoleScopes = map[string][]string{
+	RoleMember:    {"account:read", "account:write"},
+	RoleCoach:     {"athletes:read", "athletes:write"},
+	RoleClubAdmin: {"club:read", "club:manage"},
+	RoleStaff:     {"staff:accounts", "staff:moderation"},
+}
+
+// ValidRole returns true if the provided role is known.
+func ValidRole(role string) bool {
+	_, ok := RoleScopes[role]
+	return ok
+}
+
+// ScopesForRoles returns the deduplicated scopes granted by the roles.
+func ScopesForRoles(roles []string) []string {
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, role := range roles {
+		for _, scope := range RoleScopes[role] {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	return scopes
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// Session is a login of an account on a single device. A session is
+// created whenever a new refresh token family is started and the ID of
+// the session is the family ID. TokenID is the ID of the most recently
+// issued refresh token of the session.
+type Session struct {
+	ID         string    `json:"id"`
+	AccountID  string    `json:"account_id"`
+	TokenID    string    `json:"token_id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+// UsernameChange records a username an account gave up for a new one.
+// The history resolves old handles and is reviewed by staff during
+// moderation.
+type UsernameChange struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	AccountID   primitive.ObjectID `json:"account_id" bson:"account_id"`
+	OldUsername string             `json:"old_username" bson:"old_username"`
+	NewUsername string             `json:"new_username" bson:"new_username"`
+	ChangedAt   time.Time          `json:"changed_at" bson:"changed_at"`
+}
+
+// UsernameChangeWait returns how long an account that changed its
+// username at the provided times has to wait before it may change it
+// again, given limit changes per period days. Zero means a change is
+// allowed now and so does a limit of zero.
+func UsernameChangeWait(changedAt []time.Time, now time.Time, limit int, period int) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+
+	since := now.AddDate(0, 0, -period)
+	recent := make([]time.Time, 0, len(changedAt))
+	for _, t := range changedAt {
+		if t.After(since) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) < limit {
+		return 0
+	}
+
+	// Another change is allowed once enough changes fall out of the
+	// period to leave room for one more.
+	sort.Slice(recent, func(i, j int) bool { return recent[i].Before(recent[j]) })
+	return recent[len(recent)-limit].AddDate(0, 0, period).Sub(now)
+}
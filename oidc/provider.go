@@ -0,0 +1,290 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"tc-server/config"
+	"time"
+)
+
+// keyRefreshInterval is the minimum time between two JWKS fetches
+// triggered by an unknown key ID.
+const keyRefreshInterval = time.Minute
+
+// Provider is an OpenID Connect provider accounts can sign in with using
+// the authorization code flow with PKCE. Endpoints and signing keys are
+// discovered from the issuer and cached.
+type Provider struct {
+	Name   string
+	conf   config.OIDCProviderConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *providerMetadata
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the ID token claims of an authenticated provider account.
+type Claims struct {
+	Email             string       `json:"email"`
+	EmailVerified     flexibleBool `json:"email_verified"`
+	Name              string       `json:"name"`
+	PreferredUsername string       `json:"preferred_username"`
+	Nonce             string       `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// flexibleBool accepts booleans encoded as JSON strings, which some
+// providers use for email_verified.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	*b = flexibleBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// New creates a provider from its configuration. A nil client uses a
+// client with a ten second timeout.
+func New(name string, conf config.OIDCProviderConfig, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Provider{Name: name, conf: conf, client: client, keys: make(map[string]interface{})}
+}
+
+// NewProviders creates every configured provider keyed by its name.
+func NewProviders(conf *config.OIDCConfig) map[string]*Provider {
+	providers := make(map[string]*Provider)
+	for name, provider := range conf.Providers {
+		providers[name] = New(name, provider, nil)
+	}
+
+	return providers
+}
+
+// NewState returns a random value suitable as state or nonce parameter.
+func NewState() (string, error) {
+	return randomString(32)
+}
+
+// AuthCodeURL returns the URL of the provider authorization page the user
+// agent is sent to.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.conf.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.conf.ClientID)
+	values.Set("redirect_uri", p.conf.RedirectURL)
+	values.Set("scope", strings.Join(scopes, " "))
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", Challenge(verifier))
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return meta.AuthorizationEndpoint + separator + values.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the claims of the
+// verified ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.conf.RedirectURL)
+	form.Set("client_id", p.conf.ClientID)
+	form.Set("client_secret", p.conf.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+
+	if len(token.IDToken) == 0 {
+		return nil, errors.New("token response is missing an id token")
+	}
+
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an
+// ID token and returns its claims.
+func (p *Provider) Verify(ctx context.Context, idToken string, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "ES256"}))
+
+	var claims Claims
+	_, err = parser.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, meta, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if claims.Issuer != meta.Issuer {
+		return nil, errors.New("id token issuer mismatch")
+	}
+
+	if !claims.VerifyAudience(p.conf.ClientID, true) {
+		return nil, errors.New("id token audience mismatch")
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("id token has no expiry")
+	}
+
+	if len(claims.Subject) == 0 {
+		return nil, errors.New("id token has no subject")
+	}
+
+	if claims.Nonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+
+	return &claims, nil
+}
+
+// discover fetches and caches the provider metadata of the issuer.
+func (p *Provider) discover(ctx context.Context) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	endpoint := strings.TrimSuffix(p.conf.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta providerMetadata
+	if err := p.do(req, &meta); err != nil {
+		return nil, fmt.Errorf("failed to discover provider %s: %w", p.Name, err)
+	}
+
+	if meta.Issuer != p.conf.Issuer {
+		return nil, fmt.Errorf("provider %s reports issuer %s, expected %s", p.Name, meta.Issuer, p.conf.Issuer)
+	}
+
+	p.metadata = &meta
+	return p.metadata, nil
+}
+
+// key returns the signing key with the provided ID, refetching the key
+// set if the key is unknown.
+func (p *Provider) key(ctx context.Context, meta *providerMetadata, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var set jsonWebKeySet
+	if err := p.do(req, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// do performs the request and decodes the JSON response into v.
+func (p *Provider) do(req *http.Request, v interface{}) error {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
@@ -5,3 +5,99 @@ type CreateAccountRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
+
+type LoginAccountRequest struct {
+	Identifier string `json:"identifier"` // Username or email address
+	Password   string `json:"password"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// UpdateProfileRequest applies a partial profile update. Omitted fields
+// are left untouched while empty strings clear the field.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	Avatar      *string `json:"avatar"`
+}
+
+type UpdateRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// MFACodeRequest carries a second factor, either a TOTP code or a one-time
+// recovery code.
+type MFACodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	MFACodeRequest
+}
+
+// PasskeyCredential is a PublicKeyCredential returned by the browser.
+// Binary values are base64url encoded.
+type PasskeyCredential struct {
+	ID       string                    `json:"id"`
+	Type     string                    `json:"type"`
+	Response PasskeyCredentialResponse `json:"response"`
+}
+
+type PasskeyCredentialResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject,omitempty"`
+	AuthenticatorData string `json:"authenticatorData,omitempty"`
+	Signature         string `json:"signature,omitempty"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+type PasskeyRegisterRequest struct {
+	Name       string            `json:"name"`
+	Credential PasskeyCredential `json:"credential"`
+}
+
+// PasskeyLoginBeginRequest optionally names the account logging in. If
+// omitted any discoverable credential of the relying party is accepted.
+type PasskeyLoginBeginRequest struct {
+	Identifier string `json:"identifier"`
+}
+
+type PasskeyLoginRequest struct {
+	SessionID  string            `json:"session_id"`
+	Credential PasskeyCredential `json:"credential"`
+}
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// ReauthRequest confirms the identity of the account owner before a
+// sensitive change. The password is required for accounts that have one
+// and a second factor for accounts with two-factor authentication.
+// Accounts without a password must have signed in recently instead.
+type ReauthRequest struct {
+	Password string `json:"password"`
+	MFACodeRequest
+}
+
+type ChangeEmailRequest struct {
+	Email string `json:"email"`
+	ReauthRequest
+}
+
+type ChangeUsernameRequest struct {
+	Username string `json:"username"`
+}
@@ -1,7 +1,215 @@
 package response
 
+import (
+	"tc-server/model"
+	"tc-server/webauthn"
+	"time"
+)
+
 type AccountCreateResponse struct {
 	ID           string `json:"id"`
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
+
+type AccountLoginResponse struct {
+	ID           string `json:"id"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// AccountMFARequiredResponse is returned by login in place of a token pair
+// when the account has a second factor. The MFA token must be exchanged
+// together with a second factor for the token pair.
+type AccountMFARequiredResponse struct {
+	ID          string `json:"id"`
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type AccountRefreshResponse struct {
+	ID           string `json:"id"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type AccountEmailResponse struct {
+	Value       string     `json:"value"`
+	Confirmed   bool       `json:"confirmed"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+type AccountMetadataResponse struct {
+	Profile   model.AccountProfile `json:"profile"`
+	CreatedAt time.Time            `json:"created_at"`
+	LastSeen  time.Time            `json:"last_seen_at"`
+}
+
+// AccountResponse is the private representation of an account which
+// is only returned to the account owner. It intentionally omits the
+// password hash stored on the account document.
+type AccountResponse struct {
+	ID           string                   `json:"id"`
+	Username     string                   `json:"username"`
+	Email        AccountEmailResponse     `json:"email"`
+	PendingEmail string                   `json:"pending_email,omitempty"`
+	Roles        []string                 `json:"roles"`
+	MFAEnabled   bool                     `json:"mfa_enabled"`
+	Identities   []model.AccountIdentity  `json:"identities"`
+	Deletion     *AccountDeletionResponse `json:"deletion,omitempty"`
+	Metadata     AccountMetadataResponse  `json:"metadata"`
+}
+
+// AccountDeletionResponse describes the pending deletion of an account.
+type AccountDeletionResponse struct {
+	RequestedAt time.Time `json:"requested_at"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+}
+
+// NewAccountResponse builds the private representation of an account.
+func NewAccountResponse(account model.Account) AccountResponse {
+	res := AccountResponse{
+		ID:       account.ID.Hex(),
+		Username: account.Username,
+		Email: AccountEmailResponse{
+			Value:     account.Email.Value,
+			Confirmed: account.Email.Confirmed,
+		},
+		Roles:      account.EffectiveRoles(),
+		MFAEnabled: account.MFA.Enabled,
+		Identities: account.Identities,
+		Metadata: AccountMetadataResponse{
+			Profile:   account.Metadata.Profile,
+			CreatedAt: account.Metadata.CreatedAt,
+			LastSeen:  account.Metadata.LastSeen,
+		},
+	}
+
+	if res.Identities == nil {
+		res.Identities = []model.AccountIdentity{}
+	}
+
+	if account.PendingEmail != nil {
+		res.PendingEmail = account.PendingEmail.Value
+	}
+
+	if account.Deletion != nil {
+		res.Deletion = &AccountDeletionResponse{
+			RequestedAt: account.Deletion.RequestedAt,
+			ScheduledAt: account.Deletion.ScheduledAt,
+		}
+	}
+
+	if account.Email.Confirmed {
+		confirmedAt := account.Email.ConfirmedAt
+		res.Email.ConfirmedAt = &confirmedAt
+	}
+
+	return res
+}
+
+// PublicAccountResponse is the limited representation of an account
+// returned to anyone other than the account owner.
+type PublicAccountResponse struct {
+	ID          string            `json:"id"`
+	Username    string            `json:"username"`
+	DisplayName string            `json:"display_name,omitempty"`
+	Avatar      string            `json:"avatar,omitempty"`
+	Avatars     map[string]string `json:"avatars,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// NewPublicAccountResponse builds the public representation of an account.
+func NewPublicAccountResponse(account model.Account) PublicAccountResponse {
+	return PublicAccountResponse{
+		ID:          account.ID.Hex(),
+		Username:    account.Username,
+		DisplayName: account.Metadata.Profile.DisplayName,
+		Avatar:      account.Metadata.Profile.Avatar,
+		Avatars:     account.Metadata.Profile.Avatars,
+		CreatedAt:   account.Metadata.CreatedAt,
+	}
+}
+
+type PasskeyLoginBeginResponse struct {
+	SessionID string                  `json:"session_id"`
+	Options   webauthn.RequestOptions `json:"options"`
+}
+
+type OIDCAuthorizeResponse struct {
+	URL string `json:"url"`
+}
+
+// SessionResponse is the representation of a session returned to the
+// account owner. Current marks the session of the requesting token.
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// NewSessionResponse builds the representation of a session.
+func NewSessionResponse(session model.Session, current bool) SessionResponse {
+	return SessionResponse{
+		ID:         session.ID,
+		DeviceName: session.DeviceName,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+		Current:    current,
+	}
+}
+
+// DataExportResponse describes a personal data export. DownloadURL is a
+// signed link that is only set while the archive is available.
+type DataExportResponse struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+// NewDataExportResponse builds the representation of a data export.
+func NewDataExportResponse(export model.DataExport, downloadURL string) DataExportResponse {
+	res := DataExportResponse{
+		ID:          export.ID.Hex(),
+		Status:      export.Status,
+		RequestedAt: export.RequestedAt,
+		DownloadURL: downloadURL,
+	}
+
+	if !export.CompletedAt.IsZero() {
+		completedAt := export.CompletedAt
+		res.CompletedAt = &completedAt
+	}
+
+	if !export.ExpiresAt.IsZero() {
+		expiresAt := export.ExpiresAt
+		res.ExpiresAt = &expiresAt
+	}
+
+	return res
+}
+
+// UsernameRedirectResponse points a lookup of a previous username to
+// the account now known under a new username.
+type UsernameRedirectResponse struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
@@ -1,11 +1,19 @@
 package server
 
 import (
+	"context"
+	"fmt"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"tc-server/config"
 	"tc-server/controller"
 	"tc-server/db"
+	"tc-server/mail"
+	"tc-server/middleware"
+	"tc-server/oidc"
+	"tc-server/storage"
+	"tc-server/util"
+	"tc-server/webauthn"
 )
 
 // Init will initialize the Gin server and all
@@ -15,20 +23,10 @@ import (
 func Init(config *config.FullConfig) {
 	gin.SetMode(config.Gin.Env)
 
-	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowOrigins = config.Gin.Origins
-	corsConfig.AllowCredentials = true
-	corsConfig.AddAllowMethods("GET", "POST")
-	corsConfig.AddAllowHeaders(
-		"Content-Type", "X-XSRF-TOKEN", "Accept",
-		"Origin", "X-Requested-With", "Authorization",
-		"Set-Cookie", "Access-Control-Allow-Origin")
-
-	router := gin.New()
-	// middleware
-	router.Use(gin.Recovery())
-	router.Use(gin.Logger())
-	router.Use(cors.New(corsConfig))
+	router, err := NewRouter(config)
+	if err != nil {
+		panic("failed to initialize gin: " + err.Error())
+	}
 
 	// db & cache
 	redis, err := db.InitRedis(&config.Cache)
@@ -40,16 +38,169 @@ func Init(config *config.FullConfig) {
 		panic("failed to establish connection with mongo database: " + err.Error())
 	}
 
+	// rate limiting
+	limiter, err := middleware.NewRateLimiter(redis, &config.RateLimit)
+	if err != nil {
+		panic("invalid rate limit config: " + err.Error())
+	}
+	router.Use(limiter.Limit("global"))
+
+	// mail
+	mailer, err := mail.New(&config.Mail)
+	if err != nil {
+		panic("failed to initialize mailer: " + err.Error())
+	}
+	outbox := mail.NewOutbox(mailer, db.MongoParams{
+		Client:         mongo,
+		DBName:         config.Mongo.DatabaseName,
+		CollectionName: "mail_outbox",
+	}, &config.Mail.Outbox)
+	go outbox.Run(context.Background())
+
+	// blob storage
+	blobs, err := storage.New(&config.Storage)
+	if err != nil {
+		panic("failed to initialize blob storage: " + err.Error())
+	}
+	if config.Storage.Driver == "local" && len(config.Storage.Local.Route) > 0 {
+		router.Static(config.Storage.Local.Route, config.Storage.Local.Directory)
+	}
+	exports, err := storage.NewPrivate(&config.Export.Storage, &config.Storage)
+	if err != nil {
+		panic("failed to initialize export storage: " + err.Error())
+	}
+	if len(config.Export.SigningKey) == 0 {
+		panic("export signing key is not configured")
+	}
+
+	// login throttling
+	if err := ApplyLoginThrottleDefaults(&config.Auth.LoginThrottle); err != nil {
+		panic("invalid login throttle config: " + err.Error())
+	}
+
+	// token keys
+	accessKeys, err := util.NewAccessTokenKeyring(&config.Auth)
+	if err != nil {
+		panic("failed to load access token keyring: " + err.Error())
+	}
+	for _, kid := range accessKeys.Removable() {
+		fmt.Println("Access token key " + kid + " is past its retirement window and can be removed")
+	}
+
+	refreshKey, err := util.NewRefreshTokenKey(&config.Auth)
+	if err != nil {
+		panic("failed to load refresh token key: " + err.Error())
+	}
+
+	mfaKey, err := util.NewMFATokenKey(&config.Auth)
+	if err != nil {
+		panic("failed to load mfa token key: " + err.Error())
+	}
+
+	secrets, err := util.NewSecretBox(config.Auth.MFASecretKey)
+	if err != nil {
+		panic("failed to initialize secret encryption: " + err.Error())
+	}
+
+	passwords, err := util.NewPasswordHasher(&config.Auth.PasswordHashing)
+	if err != nil {
+		panic("failed to initialize password hashing: " + err.Error())
+	}
+
+	policy, err := util.NewPasswordPolicy(&config.Auth.PasswordPolicy)
+	if err != nil {
+		panic("failed to initialize password policy: " + err.Error())
+	}
+
 	gc := controller.GlobalController{
-		Config: config,
-		Mongo:  mongo,
-		Redis:  redis,
+		Config:  config,
+		Mongo:   mongo,
+		Redis:   redis,
+		Mail:    outbox,
+		Blobs:   blobs,
+		Exports: exports,
+		Limiter: limiter,
+
+		AccessKeys: accessKeys,
+		RefreshKey: refreshKey,
+		MFAKey:     mfaKey,
+		Secrets:    secrets,
+		Passwords:  passwords,
+		Policy:     policy,
+		WebAuthn: &webauthn.RelyingParty{
+			ID:      config.WebAuthn.RPID,
+			Name:    config.WebAuthn.RPName,
+			Origins: config.WebAuthn.Origins,
+		},
+		OIDC: oidc.NewProviders(&config.OIDC),
 	}
 
+	go gc.RunAccountPurge(context.Background())
+	go gc.RunDataExports(context.Background())
+
 	// apply routes
 	gc.ApplyAccountRoutes(router)
+	gc.ApplyStaffRoutes(router)
+	gc.ApplyWellKnownRoutes(router)
 
 	if err := router.Run(":" + config.Gin.Port); err != nil {
 		panic("failed to start gin: " + err.Error())
 	}
 }
+
+// NewRouter creates the Gin engine with the middleware shared by every
+// route. Forwarded client IP headers are only honored when sent by one
+// of the configured trusted proxies, since rate limits and login
+// throttling are keyed by the client IP address.
+func NewRouter(config *config.FullConfig) (*gin.Engine, error) {
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOrigins = config.Gin.Origins
+	corsConfig.AllowCredentials = true
+	corsConfig.AddAllowMethods("GET", "POST", "PUT", "PATCH", "DELETE")
+	corsConfig.AddAllowHeaders(
+		"Content-Type", "X-XSRF-TOKEN", "Accept",
+		"Origin", "X-Requested-With", "Authorization",
+		"Set-Cookie", "Access-Control-Allow-Origin",
+		"X-Device-Name")
+	corsConfig.ExposeHeaders = []string{
+		"X-RateLimit-Limit", "X-RateLimit-Remaining",
+		"X-RateLimit-Reset", "Retry-After"}
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(config.Gin.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	// middleware
+	router.Use(gin.Recovery())
+	router.Use(gin.Logger())
+	router.Use(cors.New(corsConfig))
+
+	return router, nil
+}
+
+// ApplyLoginThrottleDefaults fills in the window and lockouts of the
+// login throttle left empty and rejects negative or inverted values. A
+// lockout without duration would be stored without expiry and lock the
+// account or IP address out for good.
+func ApplyLoginThrottleDefaults(conf *config.LoginThrottleConfig) error {
+	if conf.Window == 0 {
+		conf.Window = 15
+	}
+	if conf.BaseLockout == 0 {
+		conf.BaseLockout = 60
+	}
+	if conf.MaxLockout == 0 {
+		conf.MaxLockout = max(3600, conf.BaseLockout)
+	}
+
+	if conf.Window < 0 || conf.BaseLockout < 0 || conf.MaxLockout < 0 {
+		return fmt.Errorf("window and lockouts must be positive")
+	}
+
+	if conf.MaxLockout < conf.BaseLockout {
+		return fmt.Errorf("max lockout %ds is shorter than the base lockout %ds", conf.MaxLockout, conf.BaseLockout)
+	}
+
+	return nil
+}
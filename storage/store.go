@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"tc-server/config"
+)
+
+// ErrNotFound is returned when a requested blob does not exist.
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore persists binary objects under slash separated keys and
+// exposes them through a URL. Implementations must be safe for
+// concurrent use.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+// New returns the BlobStore selected by the driver of the provided
+// storage configuration.
+func New(conf *config.StorageConfig) (BlobStore, error) {
+	switch conf.Driver {
+	case "local", "":
+		return &LocalStore{
+			Directory: conf.Local.Directory,
+			BaseURL:   conf.Local.BaseURL,
+		}, nil
+	case "s3":
+		return &S3Store{
+			Endpoint:  conf.S3.Endpoint,
+			Region:    conf.S3.Region,
+			Bucket:    conf.S3.Bucket,
+			AccessKey: conf.S3.AccessKey,
+			SecretKey: conf.S3.SecretKey,
+			PublicURL: conf.S3.PublicURL,
+			PathStyle: conf.S3.PathStyle,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", conf.Driver)
+	}
+}
+
+// NewPrivate returns the BlobStore of the provided storage configuration
+// for blobs that must only ever be handed out by the server itself. The
+// store may not be served through a static route and may not share its
+// location with the public store.
+func NewPrivate(conf *config.StorageConfig, public *config.StorageConfig) (BlobStore, error) {
+	switch conf.Driver {
+	case "local", "":
+		if len(conf.Local.Directory) == 0 {
+			return nil, errors.New("private storage directory is not configured")
+		}
+
+		if len(conf.Local.Route) > 0 {
+			return nil, errors.New("private storage must not be served")
+		}
+
+		served := (public.Driver == "local" || public.Driver == "") && len(public.Local.Route) > 0
+		if served && withinDirectory(public.Local.Directory, conf.Local.Directory) {
+			return nil, fmt.Errorf("private storage directory %q is served through %s", conf.Local.Directory, public.Local.Route)
+		}
+	case "s3":
+		if public.Driver == "s3" && public.S3.Endpoint == conf.S3.Endpoint && public.S3.Bucket == conf.S3.Bucket {
+			return nil, fmt.Errorf("private storage must not share the public bucket %q", conf.S3.Bucket)
+		}
+	}
+
+	return New(conf)
+}
+
+// withinDirectory reports whether path is the directory dir or lies
+// inside of it.
+func withinDirectory(dir string, path string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return true
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
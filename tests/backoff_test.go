@@ -0,0 +1,25 @@
+package tests
+
+import (
+	"tc-server/util"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	for _, c := range []struct {
+		step int
+		want time.Duration
+	}{
+		{0, 0},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{7, time.Hour},
+		{1000, time.Hour},
+	} {
+		if got := util.Backoff(time.Minute, c.step, time.Hour); got != c.want {
+			t.Errorf("Backoff(%d) = %v, want %v", c.step, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"tc-server/config"
+	"tc-server/controller"
+	"tc-server/db"
+	"tc-server/mail"
+	"tc-server/model"
+	"tc-server/util"
+	"testing"
+	"time"
+)
+
+// stubPassword is the password hashed by passwordHash.
+const stubPassword = "correct horse battery staple"
+
+// accountStubs bundles an account controller backed by the Mongo and
+// Redis stubs.
+type accountStubs struct {
+	ac    *controller.AccountController
+	mongo *mongoStub
+	redis *redisStub
+}
+
+// newAccountStubs creates an account controller backed by the Mongo and
+// Redis stubs. Mail is queued to the stubbed outbox collection and
+// passwords are hashed with the cheapest bcrypt cost.
+func newAccountStubs(t *testing.T) *accountStubs {
+	gin.SetMode(gin.TestMode)
+
+	mongoStub, mongoClient := newMongoStub(t)
+	redisStub, redisClient := newRedisStub(t)
+
+	passwords, err := util.NewPasswordHasher(&config.PasswordHashingConfig{
+		Algorithm:  util.PasswordAlgorithmBcrypt,
+		BcryptCost: 4,
+	})
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() returned error: %v", err)
+	}
+
+	conf := &config.FullConfig{}
+	conf.Mongo.DatabaseName = "stub"
+
+	outbox := mail.NewOutbox(&mail.MemoryMailer{}, db.MongoParams{
+		Client:         mongoClient,
+		DBName:         conf.Mongo.DatabaseName,
+		CollectionName: "mail_outbox",
+	}, &conf.Mail.Outbox)
+
+	return &accountStubs{
+		ac: &controller.AccountController{
+			GlobalController: &controller.GlobalController{
+				Config:    conf,
+				Mongo:     mongoClient,
+				Redis:     redisClient,
+				Mail:      outbox,
+				Passwords: passwords,
+			},
+			CollectionName: "account",
+		},
+		mongo: mongoStub,
+		redis: redisStub,
+	}
+}
+
+// params returns the parameters of a stubbed collection.
+func (s *accountStubs) params(collection string) db.MongoParams {
+	return db.MongoParams{
+		Client:         s.ac.GlobalController.Mongo,
+		DBName:         s.ac.GlobalController.Config.Mongo.DatabaseName,
+		CollectionName: collection,
+	}
+}
+
+// passwordHash returns a hash of stubPassword.
+func (s *accountStubs) passwordHash(t *testing.T) string {
+	hash, err := s.ac.GlobalController.Passwords.Hash(stubPassword)
+	if err != nil {
+		t.Fatalf("Hash() returned error: %v", err)
+	}
+
+	return hash
+}
+
+// insertAccount stores the account and returns its ID.
+func (s *accountStubs) insertAccount(t *testing.T, account model.Account) primitive.ObjectID {
+	if account.ID.IsZero() {
+		account.ID = primitive.NewObjectID()
+	}
+
+	if _, err := db.InsertDocument(s.params("account"), account); err != nil {
+		t.Fatalf("InsertDocument() returned error: %v", err)
+	}
+
+	return account.ID
+}
+
+// signedIn returns middleware authenticating requests as the account,
+// signed in at authTime.
+func signedIn(accountId primitive.ObjectID, authTime time.Time) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("accountId", accountId.Hex())
+		ctx.Set("authTime", authTime)
+	}
+}
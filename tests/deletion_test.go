@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tc-server/db"
+	"tc-server/model"
+	"testing"
+	"time"
+)
+
+func TestRunAccountPurgePurgesDueAccounts(t *testing.T) {
+	stubs := newAccountStubs(t)
+	stubs.ac.GlobalController.Config.Account.IdentifierCooldown = 30
+
+	now := time.Now()
+	cases := []struct {
+		username string
+		deletion *model.AccountDeletion
+		purged   bool
+	}{
+		{"active", nil, false},
+		{"scheduled", &model.AccountDeletion{RequestedAt: now, ScheduledAt: now.Add(24 * time.Hour)}, false},
+		{"due", &model.AccountDeletion{RequestedAt: now.AddDate(0, 0, -30), ScheduledAt: now.Add(-time.Hour)}, true},
+		{"purging", &model.AccountDeletion{RequestedAt: now.AddDate(0, 0, -30), ScheduledAt: now.Add(-time.Hour), PurgeStartedAt: now.Add(-time.Minute)}, false},
+		{"stalled", &model.AccountDeletion{RequestedAt: now.AddDate(0, 0, -30), ScheduledAt: now.Add(-2 * time.Hour), PurgeStartedAt: now.Add(-time.Hour)}, true},
+	}
+	for _, c := range cases {
+		stubs.insertAccount(t, model.Account{Username: c.username, Deletion: c.deletion})
+	}
+
+	// A cancelled context stops the purge after its first run.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stubs.ac.GlobalController.RunAccountPurge(ctx)
+
+	for _, c := range cases {
+		purged := stubs.mongo.count("account", bson.M{"username": c.username}) == 0
+		if purged != c.purged {
+			t.Errorf("RunAccountPurge() purged %s: %v, want %v", c.username, purged, c.purged)
+		}
+
+		reserved := stubs.mongo.count("reserved_identifier", bson.M{"value": c.username}) > 0
+		if reserved != c.purged {
+			t.Errorf("RunAccountPurge() reserved %s: %v, want %v", c.username, reserved, c.purged)
+		}
+	}
+}
+
+func TestReservedIdentifiersAreRefused(t *testing.T) {
+	stubs := newAccountStubs(t)
+	accountId := stubs.insertAccount(t, model.Account{Username: "alice"})
+
+	now := time.Now()
+	for _, reservation := range []model.ReservedIdentifier{
+		{AccountID: primitive.NewObjectID(), Value: "bob", ExpiresAt: now.Add(time.Hour)},
+		{AccountID: primitive.NewObjectID(), Value: "carol", ExpiresAt: now.Add(-time.Hour)},
+		{AccountID: accountId, Value: "alicia", ExpiresAt: now.Add(time.Hour)},
+	} {
+		reservation.Kind = model.ReservedUsername
+		reservation.Reason = model.ReservedReasonDeleted
+		reservation.CreatedAt = now
+		if _, err := db.InsertDocument(stubs.params("reserved_identifier"), reservation); err != nil {
+			t.Fatalf("InsertDocument() returned error: %v", err)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/availability/:key/:value", stubs.ac.GetAccountAvailability())
+	router.PUT("/username", signedIn(accountId, now), stubs.ac.ChangeUsername())
+
+	for _, c := range []struct {
+		username string
+		code     int
+	}{
+		{"bob", http.StatusConflict},
+		{"carol", http.StatusOK},
+		{"alicia", http.StatusConflict},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/availability/username/"+c.username, nil))
+
+		if w.Code != c.code {
+			t.Errorf("GetAccountAvailability(%s) responded %d, want %d", c.username, w.Code, c.code)
+		}
+	}
+
+	// Usernames reserved from the account itself remain available to it.
+	for _, c := range []struct {
+		username string
+		code     int
+	}{
+		{"bob", http.StatusConflict},
+		{"alicia", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"username":"` + c.username + `"}`)
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/username", body))
+
+		if w.Code != c.code {
+			t.Errorf("ChangeUsername(%s) responded %d, want %d", c.username, w.Code, c.code)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package tests
+
+import (
+	"tc-server/util"
+	"testing"
+)
+
+func TestDeviceName(t *testing.T) {
+	cases := []struct {
+		userAgent, want string
+	}{
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "Chrome on macOS"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1", "Safari on iPhone"},
+		{"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.4.0", "Unknown device"},
+		{"", "Unknown device"},
+	}
+
+	for _, c := range cases {
+		if got := util.DeviceName(c.userAgent); got != c.want {
+			t.Errorf("DeviceName(%q) == %q, want %q", c.userAgent, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"net/http/httptest"
+	"tc-server/db"
+	"tc-server/model"
+	"testing"
+	"time"
+)
+
+// confirmEmailChange confirms the change of the account to the email
+// address and returns the response status.
+func confirmEmailChange(t *testing.T, stubs *accountStubs, account model.Account, email string) int {
+	raw, _ := json.Marshal(model.Confirmation{
+		AccountID: account.ID.Hex(),
+		Field:     model.ConfirmEmailChange,
+		Value:     email,
+	})
+
+	redisp := db.RedisParams{RedisClient: stubs.ac.GlobalController.Redis}
+	if _, err := db.SetCacheValue(redisp, "confirm:change", string(raw), 60); err != nil {
+		t.Fatalf("SetCacheValue() returned error: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/confirm/:confirmId", stubs.ac.Confirm())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/confirm/change", nil))
+
+	return w.Code
+}
+
+func TestConfirmEmailChangeRefusesTakenAddress(t *testing.T) {
+	stubs := newAccountStubs(t)
+
+	account := model.Account{
+		Username:     "alice",
+		Email:        model.AccountConfirmable{Value: "alice@example.com", Confirmed: true},
+		PendingEmail: &model.AccountPendingEmail{Value: "new@example.com", RequestedAt: time.Now()},
+	}
+	account.ID = stubs.insertAccount(t, account)
+
+	// Another account took the address after the change was requested.
+	stubs.insertAccount(t, model.Account{
+		Username: "bob",
+		Email:    model.AccountConfirmable{Value: "new@example.com"},
+	})
+
+	if code := confirmEmailChange(t, stubs, account, "new@example.com"); code != http.StatusConflict {
+		t.Errorf("Confirm() responded %d, want %d", code, http.StatusConflict)
+	}
+
+	if n := stubs.mongo.count("account", bson.M{"email.value": "new@example.com"}); n != 1 {
+		t.Errorf("Confirm() left %d accounts using the address, want 1", n)
+	}
+}
+
+func TestConfirmEmailChangeRefusesSupersededConfirmation(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		pending    string
+		concurrent bool
+	}{
+		{"newer change", "newer@example.com", false},
+		{"reverted", "", false},
+		{"concurrent newer change", "new@example.com", true},
+	} {
+		stubs := newAccountStubs(t)
+
+		account := model.Account{
+			Username: "alice",
+			Email:    model.AccountConfirmable{Value: "alice@example.com", Confirmed: true},
+		}
+		if len(c.pending) > 0 {
+			account.PendingEmail = &model.AccountPendingEmail{Value: c.pending, RequestedAt: time.Now()}
+		}
+		account.ID = stubs.insertAccount(t, account)
+
+		// A newer change request replaces the pending address after it
+		// was checked.
+		if c.concurrent {
+			stubs.mongo.before("update", func() {
+				_, err := db.UpdateDocumentByFilter[model.Account](stubs.params("account"), account.ID, bson.M{
+					"$set": bson.M{"pending_email": model.AccountPendingEmail{Value: "newer@example.com", RequestedAt: time.Now()}},
+				})
+				if err != nil {
+					t.Errorf("UpdateDocumentByFilter() returned error: %v", err)
+				}
+			})
+		}
+
+		if code := confirmEmailChange(t, stubs, account, "new@example.com"); code != http.StatusGone {
+			t.Errorf("Confirm(%s) responded %d, want %d", c.name, code, http.StatusGone)
+		}
+
+		if n := stubs.mongo.count("account", bson.M{"email.value": "alice@example.com"}); n != 1 {
+			t.Errorf("Confirm(%s) replaced the email address", c.name)
+		}
+
+		if len(stubs.redis.keys("confirm:")) > 0 {
+			t.Errorf("Confirm(%s) kept the superseded confirmation", c.name)
+		}
+	}
+}
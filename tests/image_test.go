@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"tc-server/util"
+	"testing"
+)
+
+// halves returns an image whose left half is red and right half is blue.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestCreateThumbnails(t *testing.T) {
+	var buf bytes.Buffer
+	_ = png.Encode(&buf, halves(300, 200))
+
+	thumbnails, contentType, err := util.CreateThumbnails(buf.Bytes(), []int{32, 64})
+	if err != nil {
+		t.Fatalf("CreateThumbnails() returned error: %v", err)
+	}
+
+	if contentType != "image/png" {
+		t.Errorf("CreateThumbnails() content type == %q, want image/png", contentType)
+	}
+
+	for _, size := range []int{32, 64} {
+		img, err := png.Decode(bytes.NewReader(thumbnails[size]))
+		if err != nil {
+			t.Fatalf("failed to decode %d thumbnail: %v", size, err)
+		}
+
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Errorf("thumbnail size == %dx%d, want %dx%d", b.Dx(), b.Dy(), size, size)
+		}
+	}
+
+	if _, _, err := util.CreateThumbnails([]byte("not an image"), []int{32}); err == nil {
+		t.Errorf("CreateThumbnails() accepted invalid image data")
+	}
+}
+
+func TestCreateThumbnailsOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	_ = jpeg.Encode(&buf, halves(64, 64), &jpeg.Options{Quality: 100})
+
+	// APP1 segment holding a big endian TIFF structure with a single
+	// orientation entry of 6 (rotate 90 degrees clockwise).
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	segment := append([]byte{0xFF, 0xE1, 0x00, byte(len(exif) + 2)}, exif...)
+	src := append(append([]byte{0xFF, 0xD8}, segment...), buf.Bytes()[2:]...)
+
+	thumbnails, contentType, err := util.CreateThumbnails(src, []int{64})
+	if err != nil {
+		t.Fatalf("CreateThumbnails() returned error: %v", err)
+	}
+
+	if contentType != "image/jpeg" {
+		t.Errorf("CreateThumbnails() content type == %q, want image/jpeg", contentType)
+	}
+
+	if bytes.Contains(thumbnails[64], []byte("Exif")) {
+		t.Errorf("thumbnail still contains EXIF data")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(thumbnails[64]))
+	if err != nil {
+		t.Fatalf("failed to decode thumbnail: %v", err)
+	}
+
+	// Rotating clockwise moves the red left half to the top.
+	top, _, _, _ := img.At(32, 8).RGBA()
+	bottom, _, _, _ := img.At(32, 56).RGBA()
+	if top < bottom {
+		t.Errorf("thumbnail was not rotated according to its EXIF orientation")
+	}
+}
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"tc-server/config"
+	"tc-server/util"
+	"testing"
+	"time"
+)
+
+func TestKeyring(t *testing.T) {
+	current := util.NewHMACTokenKey("current", "current-secret")
+	recent := util.NewHMACTokenKey("recent", "recent-secret")
+	expired := util.NewHMACTokenKey("expired", "expired-secret")
+
+	keyring := util.NewKeyring(current, 10*time.Minute)
+	keyring.Retire(recent, time.Now().Add(-5*time.Minute))
+	keyring.Retire(expired, time.Now().Add(-time.Hour))
+
+	for _, c := range []struct {
+		key   *util.TokenKey
+		valid bool
+	}{
+		{current, true},
+		{recent, true},
+		{expired, false},
+	} {
+		token, err := util.GenerateToken("account", c.key, 10)
+		if err != nil {
+			t.Fatalf("GenerateToken(%s) returned error: %v", c.key.ID, err)
+		}
+
+		_, err = util.ValidateTokenClaims(token, keyring)
+		if (err == nil) != c.valid {
+			t.Errorf("ValidateTokenClaims(%s) error == %v, want valid %v", c.key.ID, err, c.valid)
+		}
+	}
+
+	if keys := keyring.VerificationKeys(); len(keys) != 2 || keys[0] != current || keys[1] != recent {
+		t.Errorf("VerificationKeys() == %v, want current and recent", keys)
+	}
+
+	if removable := keyring.Removable(); len(removable) != 1 || removable[0] != "expired" {
+		t.Errorf("Removable() == %v, want [expired]", removable)
+	}
+}
+
+func TestNewAccessTokenKeyring(t *testing.T) {
+	dir := t.TempDir()
+
+	_, currentKey, _ := ed25519.GenerateKey(rand.Reader)
+	retiredPub, retiredKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	currentFile := filepath.Join(dir, "current.pem")
+	_ = os.WriteFile(currentFile, pemKey(t, currentKey), 0o600)
+
+	der, _ := x509.MarshalPKIXPublicKey(retiredPub)
+	retiredFile := filepath.Join(dir, "retired.pub.pem")
+	_ = os.WriteFile(retiredFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+
+	conf := &config.AuthConfig{
+		AccessTokenKeyID: "current",
+		AccessTokenTTL:   10,
+		AccessTokenKeys: []config.TokenKeyConfig{
+			{ID: "current", File: currentFile},
+			{ID: "retired", File: retiredFile, RetiredAt: time.Now().Format(time.RFC3339)},
+		},
+	}
+
+	keyring, err := util.NewAccessTokenKeyring(conf)
+	if err != nil {
+		t.Fatalf("NewAccessTokenKeyring() returned error: %v", err)
+	}
+
+	if keyring.Current.ID != "current" || len(keyring.VerificationKeys()) != 2 {
+		t.Errorf("NewAccessTokenKeyring() did not load the current and retired keys")
+	}
+
+	// Tokens signed by the retired private key still verify against its
+	// published public key.
+	signer, _ := util.ParseTokenKey("retired", pemKey(t, retiredKey))
+	token, _ := util.GenerateToken("account", signer, 10)
+	if _, err := util.ValidateTokenClaims(token, keyring); err != nil {
+		t.Errorf("ValidateTokenClaims() rejected a token signed by a retired key: %v", err)
+	}
+
+	conf.AccessTokenKeyID = "retired"
+	conf.AccessTokenKeys[0].RetiredAt = time.Now().Format(time.RFC3339)
+	if _, err := util.NewAccessTokenKeyring(conf); err == nil {
+		t.Errorf("NewAccessTokenKeyring() accepted a current key without a private key")
+	}
+
+	conf.AccessTokenKeyID = "missing"
+	if _, err := util.NewAccessTokenKeyring(conf); err == nil {
+		t.Errorf("NewAccessTokenKeyring() accepted a missing current key")
+	}
+}
+
+func TestNewAccessTokenKeyringRejectsEmptySecret(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		conf *config.AuthConfig
+	}{
+		{"empty access_token_pub", &config.AuthConfig{}},
+		{"keyring entry without file or secret", &config.AuthConfig{
+			AccessTokenKeyID: "current",
+			AccessTokenKeys:  []config.TokenKeyConfig{{ID: "current"}},
+		}},
+		{"retired entry without file or secret", &config.AuthConfig{
+			AccessTokenKeyID: "current",
+			AccessTokenKeys: []config.TokenKeyConfig{
+				{ID: "current", Secret: "current-secret"},
+				{ID: "retired", RetiredAt: time.Now().Format(time.RFC3339)},
+			},
+		}},
+	} {
+		if _, err := util.NewAccessTokenKeyring(c.conf); err == nil {
+			t.Errorf("NewAccessTokenKeyring(%s) accepted an empty secret", c.name)
+		}
+	}
+
+	if _, err := util.NewRefreshTokenKey(&config.AuthConfig{}); err == nil {
+		t.Errorf("NewRefreshTokenKey() accepted an empty secret")
+	}
+}
+
+func TestMFATokenKey(t *testing.T) {
+	conf := &config.AuthConfig{AccessTokenPub: "secret", MFATokenKey: "secret"}
+
+	keyring, err := util.NewAccessTokenKeyring(conf)
+	if err != nil {
+		t.Fatalf("NewAccessTokenKeyring() returned error: %v", err)
+	}
+
+	mfaKey, err := util.NewMFATokenKey(conf)
+	if err != nil {
+		t.Fatalf("NewMFATokenKey() returned error: %v", err)
+	}
+
+	mfaToken, _ := util.GenerateToken("account", mfaKey, 5)
+	if _, err := util.ValidateTokenClaims(mfaToken, keyring); err == nil {
+		t.Errorf("ValidateTokenClaims() accepted an mfa token as an access token")
+	}
+
+	if _, err := util.ValidateTokenClaims(mfaToken, mfaKey); err != nil {
+		t.Errorf("ValidateTokenClaims() rejected an mfa token: %v", err)
+	}
+
+	accessToken, _ := util.GenerateToken("account", keyring.Current, 5)
+	if _, err := util.ValidateTokenClaims(accessToken, mfaKey); err == nil {
+		t.Errorf("ValidateTokenClaims() accepted an access token as an mfa token")
+	}
+
+	if _, err := util.NewMFATokenKey(&config.AuthConfig{}); err == nil {
+		t.Errorf("NewMFATokenKey() accepted an empty key")
+	}
+}
@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tc-server/config"
+	"tc-server/model"
+	"tc-server/server"
+	"testing"
+)
+
+func TestApplyLoginThrottleDefaults(t *testing.T) {
+	conf := config.LoginThrottleConfig{MaxAccountFailures: 5}
+	if err := server.ApplyLoginThrottleDefaults(&conf); err != nil {
+		t.Fatalf("ApplyLoginThrottleDefaults() returned error: %v", err)
+	}
+
+	if conf.Window <= 0 || conf.BaseLockout <= 0 || conf.MaxLockout < conf.BaseLockout {
+		t.Errorf("ApplyLoginThrottleDefaults() left %+v", conf)
+	}
+
+	for _, c := range []config.LoginThrottleConfig{
+		{Window: -1},
+		{BaseLockout: -60},
+		{BaseLockout: 600, MaxLockout: 60},
+	} {
+		if err := server.ApplyLoginThrottleDefaults(&c); err == nil {
+			t.Errorf("ApplyLoginThrottleDefaults(%+v) accepted an invalid config", c)
+		}
+	}
+}
+
+func TestLoginLockoutExpires(t *testing.T) {
+	stubs := newAccountStubs(t)
+	accountId := stubs.insertAccount(t, model.Account{
+		Username: "alice",
+		Email:    model.AccountConfirmable{Value: "alice@example.com"},
+		Password: stubs.passwordHash(t),
+	})
+
+	// Lockouts without a duration, as configured before startup applies
+	// the defaults.
+	stubs.ac.GlobalController.Config.Auth.LoginThrottle = config.LoginThrottleConfig{MaxAccountFailures: 1}
+
+	router := gin.New()
+	router.POST("/login", stubs.ac.Login())
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"identifier":"alice","password":"wrong"}`)
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", body))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Login() responded %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	if ttl := stubs.redis.ttl("login_lockout:account:" + accountId.Hex()); ttl <= 0 {
+		t.Errorf("Login() stored the lockout with ttl %d, want an expiry", ttl)
+	}
+}
@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"strings"
+	"tc-server/config"
+	"tc-server/db"
+	"tc-server/mail"
+	"testing"
+	"time"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer := &mail.FileMailer{Directory: dir}
+
+	err := mailer.Send(mail.Message{
+		To:      "athlete@example.com",
+		Subject: "Confirm your email",
+		Text:    "https://example.com/confirm/abc",
+	})
+	if err != nil {
+		t.Fatalf("Send() returned error: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected a single message file, got %d (%v)", len(entries), err)
+	}
+
+	b, err := os.ReadFile(dir + "/" + entries[0].Name())
+	if err != nil {
+		t.Fatalf("failed to read message file: %v", err)
+	}
+
+	for _, want := range []string{"To: athlete@example.com", "Subject: Confirm your email", "https://example.com/confirm/abc"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("message file does not contain %q", want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	msg, err := mail.Render("confirm_email", "athlete@example.com", map[string]string{
+		"Link": "https://example.com/confirm/abc",
+	})
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	if msg.To != "athlete@example.com" || len(msg.Subject) == 0 {
+		t.Errorf("Render() == %+v, want recipient and subject", msg)
+	}
+
+	if !strings.Contains(msg.Text, "https://example.com/confirm/abc") || !strings.Contains(msg.HTML, `href="https://example.com/confirm/abc"`) {
+		t.Errorf("Render() did not include the link in both text and HTML bodies")
+	}
+
+	b, err := msg.Bytes("no-reply@example.com")
+	if err != nil {
+		t.Fatalf("Bytes() returned error: %v", err)
+	}
+
+	if !strings.Contains(string(b), "multipart/alternative") {
+		t.Errorf("Bytes() did not encode a multipart/alternative message")
+	}
+}
+
+func TestRenderAccountLocked(t *testing.T) {
+	msg, err := mail.Render("account_locked", "athlete@example.com", map[string]any{
+		"IP":      "203.0.113.7",
+		"Minutes": 15,
+	})
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	for _, body := range []string{msg.Text, msg.HTML} {
+		if !strings.Contains(body, "203.0.113.7") || !strings.Contains(body, "15 minutes") {
+			t.Errorf("Render() did not include the IP address and lockout duration: %s", body)
+		}
+	}
+}
+
+func TestMemoryMailer(t *testing.T) {
+	mailer, err := mail.New(&config.MailConfig{Driver: "memory"})
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	_ = mailer.Send(mail.Message{To: "athlete@example.com"})
+
+	messages := mailer.(*mail.MemoryMailer).Messages()
+	if len(messages) != 1 || messages[0].To != "athlete@example.com" {
+		t.Errorf("Messages() == %+v, want the sent message", messages)
+	}
+
+	if _, err := mail.New(&config.MailConfig{Driver: "carrier-pigeon"}); err == nil {
+		t.Errorf("New() accepted an unknown driver")
+	}
+}
+
+func TestRenderAccountDeletion(t *testing.T) {
+	msg, err := mail.Render("account_deletion", "athlete@example.com", map[string]any{
+		"Date": "November 17, 2026",
+	})
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	if msg.Subject != "Your Training Club account is scheduled for deletion" {
+		t.Errorf("Render() subject == %q", msg.Subject)
+	}
+
+	for _, body := range []string{msg.Text, msg.HTML} {
+		if !strings.Contains(body, "November 17, 2026") {
+			t.Errorf("Render() did not include the deletion date: %s", body)
+		}
+	}
+}
+
+func TestRenderDataExportReady(t *testing.T) {
+	link := "https://api.example.com/v1/account/exports/abc/download?expires=1&signature=sig"
+	msg, err := mail.Render("data_export_ready", "athlete@example.com", map[string]any{
+		"Link":    link,
+		"Expires": "November 17, 2026 12:00 UTC",
+	})
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	if !strings.Contains(msg.Text, link) {
+		t.Errorf("Render() did not include the download link: %s", msg.Text)
+	}
+
+	if !strings.Contains(msg.HTML, "November 17, 2026 12:00 UTC") {
+		t.Errorf("Render() did not include the link expiry: %s", msg.HTML)
+	}
+}
+
+func TestRenderEmailChange(t *testing.T) {
+	link := "https://api.example.com/v1/account/confirm/abc"
+
+	msg, err := mail.Render("email_change", "new@example.com", map[string]string{"Link": link})
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	if !strings.Contains(msg.Text, link) || !strings.Contains(msg.HTML, link) {
+		t.Errorf("Render() did not include the confirmation link")
+	}
+
+	notice, err := mail.Render("email_change_notice", "old@example.com", map[string]string{
+		"NewEmail": "new@example.com",
+		"Link":     link,
+	})
+	if err != nil {
+		t.Fatalf("Render() returned error: %v", err)
+	}
+
+	for _, body := range []string{notice.Text, notice.HTML} {
+		if !strings.Contains(body, "new@example.com") || !strings.Contains(body, link) {
+			t.Errorf("Render() did not include the new address and revert link: %s", body)
+		}
+	}
+}
+
+func TestOutboxEnqueueBuffersFailedInsert(t *testing.T) {
+	// Nothing listens on port 1, so every insert fails once server
+	// selection times out.
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Connect() returned error: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	outbox := mail.NewOutbox(&mail.MemoryMailer{}, db.MongoParams{
+		Client:         client,
+		DBName:         "tests",
+		CollectionName: "mail_outbox",
+	}, &config.OutboxConfig{})
+
+	err = outbox.Enqueue("athlete@example.com", "confirm_email", map[string]string{
+		"Link": "https://example.com/confirm/abc",
+	})
+	if err != nil {
+		t.Fatalf("Enqueue() returned error: %v", err)
+	}
+
+	if outbox.Buffered() != 1 {
+		t.Errorf("Buffered() == %d, want the message that failed to be stored", outbox.Buffered())
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tc-server/middleware"
+	"tc-server/model"
+	"tc-server/util"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the base32 encoding of the RFC 6238 SHA-1 test key.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// Expected codes are the last six digits of the RFC 6238 test vectors.
+	for _, c := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		code, err := util.TOTPCode(rfc6238Secret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d) returned error: %v", c.unix, err)
+		}
+
+		if code != c.code {
+			t.Errorf("TOTPCode(%d) = %s, want %s", c.unix, code, c.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code, _ := util.TOTPCode(rfc6238Secret, now)
+
+	if step, ok := util.ValidateTOTP(rfc6238Secret, code, now); !ok || step != now.Unix()/30 {
+		t.Errorf("expected current code to be valid for step %d, got %d %v", now.Unix()/30, step, ok)
+	}
+
+	if _, ok := util.ValidateTOTP(rfc6238Secret, code, now.Add(30*time.Second)); !ok {
+		t.Error("expected code of the previous step to be valid")
+	}
+
+	if _, ok := util.ValidateTOTP(rfc6238Secret, code, now.Add(2*time.Minute)); ok {
+		t.Error("expected code outside of the skew window to be invalid")
+	}
+
+	if _, ok := util.ValidateTOTP(rfc6238Secret, "12345", now); ok {
+		t.Error("expected short code to be invalid")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := util.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret returned error: %v", err)
+	}
+
+	uri := util.TOTPURI("Training Club", "john", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Training%20Club:john?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("unexpected otpauth uri %s", uri)
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	box, err := util.NewSecretBox("passphrase")
+	if err != nil {
+		t.Fatalf("NewSecretBox returned error: %v", err)
+	}
+
+	sealed, err := box.Seal("secret")
+	if err != nil {
+		t.Fatalf("Seal returned error: %v", err)
+	}
+
+	if strings.Contains(sealed, "secret") {
+		t.Error("sealed value contains the plaintext")
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil || opened != "secret" {
+		t.Errorf("Open = %q, %v, want secret", opened, err)
+	}
+
+	other, _ := util.NewSecretBox("other")
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("expected opening with a different passphrase to fail")
+	}
+
+	if _, err := util.NewSecretBox(""); err == nil {
+		t.Error("expected an empty passphrase to be rejected")
+	}
+}
+
+func TestAuthorizeRejectsMFAPendingToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keyring := util.NewKeyring(util.NewHMACTokenKey("access", "secret"), time.Minute)
+
+	token, err := util.GenerateTokenWithClaims(util.Claims{
+		AccountID: "account",
+		Scopes:    []string{model.ScopeMFAPending},
+	}, keyring.Current, 5)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClaims returned error: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/", middleware.Authorize(keyring, nil), func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
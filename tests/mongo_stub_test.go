@@ -0,0 +1,1105 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	opReply = 1
+	opQuery = 2004
+	opMsg   = 2013
+)
+
+// mongoStub is an in-memory server speaking enough of the MongoDB wire
+// protocol for the queries and updates issued by the controllers. Every
+// database shares the same collections and indexes are not enforced.
+type mongoStub struct {
+	mu          sync.Mutex
+	collections map[string][]bson.M
+	hooks       map[string][]func()
+}
+
+// newMongoStub starts a mongoStub and returns a client connected to it.
+// Both are shut down when the test finishes.
+func newMongoStub(t *testing.T) (*mongoStub, *mongo.Client) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start mongo stub: %v", err)
+	}
+
+	stub := &mongoStub{
+		collections: make(map[string][]bson.M),
+		hooks:       make(map[string][]func()),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go stub.serve(conn)
+		}
+	}()
+
+	client, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://"+listener.Addr().String()+"/?directConnection=true"))
+	if err != nil {
+		t.Fatalf("failed to connect to mongo stub: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = client.Disconnect(context.Background())
+		_ = listener.Close()
+	})
+
+	return stub, client
+}
+
+// before runs fn once right before the next command of the provided name
+// is executed, letting tests change documents between the reads and
+// writes of a handler the way a concurrent request would.
+func (s *mongoStub) before(command string, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hooks[command] = append(s.hooks[command], fn)
+}
+
+// count returns the number of documents in a collection matching the
+// filter.
+func (s *mongoStub) count(collection string, filter bson.M) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, doc := range s.collections[collection] {
+		if ok, _ := matchDocument(doc, normalize(filter).(bson.M)); ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (s *mongoStub) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+
+		length := int(binary.LittleEndian.Uint32(header[0:]))
+		requestID := binary.LittleEndian.Uint32(header[4:])
+		opCode := binary.LittleEndian.Uint32(header[12:])
+
+		body := make([]byte, length-16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+
+		var reply []byte
+		switch opCode {
+		case opQuery:
+			// flags, then the collection name followed by skip and limit
+			nameEnd := 4 + bytes.IndexByte(body[4:], 0)
+			raw, _ := readDocument(body[nameEnd+9:])
+
+			var command bson.D
+			_ = bson.Unmarshal(raw, &command)
+			reply = replyMessage(opReply, requestID, s.handle(command),
+				make([]byte, 4), make([]byte, 8), make([]byte, 4), []byte{1, 0, 0, 0})
+		case opMsg:
+			flags := binary.LittleEndian.Uint32(body)
+			sections := body[4:]
+			if flags&1 != 0 {
+				sections = sections[:len(sections)-4]
+			}
+
+			command := readSections(sections)
+			response := s.handle(command)
+			if flags&2 != 0 {
+				continue
+			}
+
+			reply = replyMessage(opMsg, requestID, response, make([]byte, 4), []byte{0})
+		default:
+			return
+		}
+
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// readDocument returns the BSON document at the start of b and the bytes
+// following it.
+func readDocument(b []byte) (bson.Raw, []byte) {
+	size := int(binary.LittleEndian.Uint32(b))
+	return bson.Raw(b[:size]), b[size:]
+}
+
+// readSections merges the document sequences of an OP_MSG into its body
+// document.
+func readSections(b []byte) bson.D {
+	var command bson.D
+	for len(b) > 0 {
+		kind := b[0]
+		b = b[1:]
+
+		if kind == 0 {
+			var raw bson.Raw
+			raw, b = readDocument(b)
+			_ = bson.Unmarshal(raw, &command)
+			continue
+		}
+
+		size := int(binary.LittleEndian.Uint32(b))
+		section := b[4:size]
+		b = b[size:]
+
+		nameEnd := bytes.IndexByte(section, 0)
+		identifier := string(section[:nameEnd])
+		section = section[nameEnd+1:]
+
+		var documents bson.A
+		for len(section) > 0 {
+			var raw bson.Raw
+			var document bson.D
+			raw, section = readDocument(section)
+			_ = bson.Unmarshal(raw, &document)
+			documents = append(documents, document)
+		}
+
+		command = append(command, bson.E{Key: identifier, Value: documents})
+	}
+
+	return command
+}
+
+func replyMessage(opCode uint32, responseTo uint32, document bson.M, prefix ...[]byte) []byte {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		raw, _ = bson.Marshal(bson.M{"ok": 0, "errmsg": err.Error()})
+	}
+
+	var body []byte
+	for _, p := range prefix {
+		body = append(body, p...)
+	}
+	body = append(body, raw...)
+
+	header := make([]byte, 16)
+	binary.LittleEndian.PutUint32(header[0:], uint32(16+len(body)))
+	binary.LittleEndian.PutUint32(header[8:], responseTo)
+	binary.LittleEndian.PutUint32(header[12:], opCode)
+	return append(header, body...)
+}
+
+func (s *mongoStub) handle(command bson.D) bson.M {
+	if len(command) == 0 {
+		return bson.M{"ok": 0, "errmsg": "empty command"}
+	}
+
+	name := command[0].Key
+	args := bson.M{}
+	for _, e := range command {
+		if e.Key == "sort" {
+			args[e.Key] = e.Value
+			continue
+		}
+		args[e.Key] = normalize(e.Value)
+	}
+
+	s.mu.Lock()
+	hooks := s.hooks[name]
+	delete(s.hooks, name)
+	s.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var response bson.M
+	var err error
+	switch strings.ToLower(name) {
+	case "hello", "ismaster":
+		response = bson.M{
+			"ismaster":            true,
+			"helloOk":             true,
+			"maxBsonObjectSize":   int32(16 * 1024 * 1024),
+			"maxMessageSizeBytes": int32(48000000),
+			"maxWriteBatchSize":   int32(100000),
+			"minWireVersion":      int32(0),
+			"maxWireVersion":      int32(13),
+			"connectionId":        int32(1),
+		}
+	case "ping", "endsessions", "killcursors":
+		response = bson.M{}
+	case "find":
+		response, err = s.find(args)
+	case "insert":
+		response, err = s.insert(args)
+	case "update":
+		response, err = s.update(args)
+	case "delete":
+		response, err = s.delete(args)
+	case "findandmodify":
+		response, err = s.findAndModify(args)
+	default:
+		err = fmt.Errorf("unsupported command %q", name)
+	}
+
+	if err != nil {
+		return bson.M{"ok": 0, "errmsg": err.Error(), "code": int32(2)}
+	}
+
+	response["ok"] = 1
+	return response
+}
+
+func (s *mongoStub) find(args bson.M) (bson.M, error) {
+	collection := args["find"].(string)
+	filter, _ := args["filter"].(bson.M)
+
+	matched, err := s.matching(collection, filter, args["sort"])
+	if err != nil {
+		return nil, err
+	}
+
+	if skip, ok := asNumber(args["skip"]); ok {
+		matched = matched[min(int(skip), len(matched)):]
+	}
+
+	if limit, ok := asNumber(args["limit"]); ok && limit != 0 {
+		if limit < 0 {
+			limit = -limit
+		}
+		matched = matched[:min(int(limit), len(matched))]
+	}
+
+	batch := bson.A{}
+	for _, i := range matched {
+		batch = append(batch, s.collections[collection][i])
+	}
+
+	return bson.M{"cursor": bson.M{"firstBatch": batch, "id": int64(0), "ns": "stub." + collection}}, nil
+}
+
+func (s *mongoStub) insert(args bson.M) (bson.M, error) {
+	collection := args["insert"].(string)
+	documents, _ := args["documents"].(bson.A)
+
+	for _, document := range documents {
+		doc := document.(bson.M)
+		if _, ok := doc["_id"]; !ok {
+			doc["_id"] = primitive.NewObjectID()
+		}
+
+		s.collections[collection] = append(s.collections[collection], doc)
+	}
+
+	return bson.M{"n": int32(len(documents))}, nil
+}
+
+func (s *mongoStub) update(args bson.M) (bson.M, error) {
+	collection := args["update"].(string)
+	updates, _ := args["updates"].(bson.A)
+
+	var matched, modified int
+	upserted := bson.A{}
+	for index, u := range updates {
+		statement := u.(bson.M)
+		filter, _ := statement["q"].(bson.M)
+		update, ok := statement["u"].(bson.M)
+		if !ok {
+			return nil, fmt.Errorf("unsupported update %v", statement["u"])
+		}
+
+		indexes, err := s.matching(collection, filter, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if multi, _ := statement["multi"].(bool); !multi && len(indexes) > 1 {
+			indexes = indexes[:1]
+		}
+
+		if len(indexes) == 0 {
+			if upsert, _ := statement["upsert"].(bool); upsert {
+				doc, err := s.upsert(collection, filter, update)
+				if err != nil {
+					return nil, err
+				}
+
+				matched++
+				upserted = append(upserted, bson.M{"index": int32(index), "_id": doc["_id"]})
+			}
+			continue
+		}
+
+		for _, i := range indexes {
+			changed, err := applyUpdate(s.collections[collection][i], update, false)
+			if err != nil {
+				return nil, err
+			}
+
+			matched++
+			if changed {
+				modified++
+			}
+		}
+	}
+
+	response := bson.M{"n": int32(matched), "nModified": int32(modified)}
+	if len(upserted) > 0 {
+		response["upserted"] = upserted
+	}
+
+	return response, nil
+}
+
+func (s *mongoStub) delete(args bson.M) (bson.M, error) {
+	collection := args["delete"].(string)
+	deletes, _ := args["deletes"].(bson.A)
+
+	var deleted int
+	for _, d := range deletes {
+		statement := d.(bson.M)
+		filter, _ := statement["q"].(bson.M)
+
+		indexes, err := s.matching(collection, filter, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if limit, _ := asNumber(statement["limit"]); limit == 1 && len(indexes) > 1 {
+			indexes = indexes[:1]
+		}
+
+		s.remove(collection, indexes)
+		deleted += len(indexes)
+	}
+
+	return bson.M{"n": int32(deleted)}, nil
+}
+
+func (s *mongoStub) findAndModify(args bson.M) (bson.M, error) {
+	collection := args["findAndModify"].(string)
+	filter, _ := args["query"].(bson.M)
+
+	indexes, err := s.matching(collection, filter, args["sort"])
+	if err != nil {
+		return nil, err
+	}
+
+	returnNew, _ := args["new"].(bool)
+	if len(indexes) == 0 {
+		update, _ := args["update"].(bson.M)
+		if upsert, _ := args["upsert"].(bool); !upsert || update == nil {
+			return bson.M{"lastErrorObject": bson.M{"n": int32(0), "updatedExisting": false}, "value": nil}, nil
+		}
+
+		doc, err := s.upsert(collection, filter, update)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if returnNew {
+			value = doc
+		}
+
+		return bson.M{
+			"lastErrorObject": bson.M{"n": int32(1), "updatedExisting": false, "upserted": doc["_id"]},
+			"value":           value,
+		}, nil
+	}
+
+	doc := s.collections[collection][indexes[0]]
+	if remove, _ := args["remove"].(bool); remove {
+		s.remove(collection, indexes[:1])
+		return bson.M{"lastErrorObject": bson.M{"n": int32(1)}, "value": doc}, nil
+	}
+
+	update, ok := args["update"].(bson.M)
+	if !ok {
+		return nil, fmt.Errorf("unsupported update %v", args["update"])
+	}
+
+	before := copyValue(doc)
+	if _, err := applyUpdate(doc, update, false); err != nil {
+		return nil, err
+	}
+
+	value := before
+	if returnNew {
+		value = doc
+	}
+
+	return bson.M{"lastErrorObject": bson.M{"n": int32(1), "updatedExisting": true}, "value": value}, nil
+}
+
+// matching returns the positions of the documents matching the filter in
+// the order of the sort specification.
+func (s *mongoStub) matching(collection string, filter bson.M, sortSpec interface{}) ([]int, error) {
+	var indexes []int
+	for i, doc := range s.collections[collection] {
+		ok, err := matchDocument(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			indexes = append(indexes, i)
+		}
+	}
+
+	spec, _ := sortSpec.(bson.D)
+	docs := s.collections[collection]
+	sort.SliceStable(indexes, func(a, b int) bool {
+		for _, e := range spec {
+			c := compareValues(lookupValue(docs[indexes[a]], e.Key), lookupValue(docs[indexes[b]], e.Key))
+			if direction, _ := asNumber(e.Value); direction < 0 {
+				c = -c
+			}
+
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	return indexes, nil
+}
+
+func (s *mongoStub) upsert(collection string, filter bson.M, update bson.M) (bson.M, error) {
+	doc := bson.M{}
+	for key, value := range filter {
+		if strings.HasPrefix(key, "$") || isOperator(value) {
+			continue
+		}
+		setPath(doc, key, normalize(value))
+	}
+
+	if _, err := applyUpdate(doc, update, true); err != nil {
+		return nil, err
+	}
+
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	s.collections[collection] = append(s.collections[collection], doc)
+	return doc, nil
+}
+
+func (s *mongoStub) remove(collection string, indexes []int) {
+	removed := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		removed[i] = true
+	}
+
+	var kept []bson.M
+	for i, doc := range s.collections[collection] {
+		if !removed[i] {
+			kept = append(kept, doc)
+		}
+	}
+
+	s.collections[collection] = kept
+}
+
+// normalize converts ordered documents into maps so documents, filters
+// and updates can be handled alike.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bson.D:
+		m := bson.M{}
+		for _, e := range v {
+			m[e.Key] = normalize(e.Value)
+		}
+		return m
+	case bson.M:
+		m := bson.M{}
+		for key, value := range v {
+			m[key] = normalize(value)
+		}
+		return m
+	case bson.A:
+		a := make(bson.A, len(v))
+		for i, value := range v {
+			a[i] = normalize(value)
+		}
+		return a
+	}
+
+	return v
+}
+
+func copyValue(v interface{}) bson.M {
+	return normalize(v).(bson.M)
+}
+
+func isOperator(v interface{}) bool {
+	m, ok := v.(bson.M)
+	if !ok {
+		return false
+	}
+
+	for key := range m {
+		return strings.HasPrefix(key, "$")
+	}
+
+	return false
+}
+
+func matchDocument(doc bson.M, filter bson.M) (bool, error) {
+	for key, cond := range filter {
+		var ok bool
+		var err error
+		switch key {
+		case "$or", "$and", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		case "$expr":
+			var result interface{}
+			result, err = evalExpression(cond, doc, nil)
+			ok = truthy(result)
+		default:
+			ok, err = matchCondition(resolvePath(doc, strings.Split(key, ".")), cond)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func matchLogical(doc bson.M, op string, cond interface{}) (bool, error) {
+	clauses, _ := cond.(bson.A)
+	for _, clause := range clauses {
+		filter, _ := clause.(bson.M)
+		ok, err := matchDocument(doc, filter)
+		if err != nil {
+			return false, err
+		}
+
+		if ok && op == "$or" {
+			return true, nil
+		}
+		if !ok && op == "$and" {
+			return false, nil
+		}
+		if ok && op == "$nor" {
+			return false, nil
+		}
+	}
+
+	return op != "$or", nil
+}
+
+// resolvePath returns the values at a dotted path, descending into every
+// element of the arrays along the way.
+func resolvePath(v interface{}, parts []string) []interface{} {
+	if len(parts) == 0 {
+		if a, ok := v.(bson.A); ok {
+			return append([]interface{}{a}, a...)
+		}
+		return []interface{}{v}
+	}
+
+	switch v := v.(type) {
+	case bson.M:
+		child, ok := v[parts[0]]
+		if !ok {
+			return nil
+		}
+		return resolvePath(child, parts[1:])
+	case bson.A:
+		var values []interface{}
+		for _, element := range v {
+			if _, ok := element.(bson.M); ok {
+				values = append(values, resolvePath(element, parts)...)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+func lookupValue(doc bson.M, path string) interface{} {
+	var v interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(bson.M)
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+
+	return v
+}
+
+func matchCondition(values []interface{}, cond interface{}) (bool, error) {
+	if !isOperator(cond) {
+		return anyEqual(values, cond), nil
+	}
+
+	for op, arg := range cond.(bson.M) {
+		var ok bool
+		switch op {
+		case "$eq":
+			ok = anyEqual(values, arg)
+		case "$ne":
+			ok = !anyEqual(values, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			for _, value := range values {
+				if !sameKind(value, arg) {
+					continue
+				}
+
+				c := compareValues(value, arg)
+				ok = ok || (op == "$gt" && c > 0) || (op == "$gte" && c >= 0) ||
+					(op == "$lt" && c < 0) || (op == "$lte" && c <= 0)
+			}
+		case "$in", "$nin":
+			candidates, _ := arg.(bson.A)
+			for _, candidate := range candidates {
+				ok = ok || anyEqual(values, candidate)
+			}
+			ok = ok == (op == "$in")
+		case "$exists":
+			ok = (len(values) > 0) == truthy(arg)
+		case "$size":
+			size, _ := asNumber(arg)
+			for _, value := range values {
+				if a, isArray := value.(bson.A); isArray && len(a) == int(size) {
+					ok = true
+				}
+			}
+		case "$elemMatch":
+			filter, _ := arg.(bson.M)
+			for _, value := range values {
+				a, isArray := value.(bson.A)
+				if !isArray {
+					continue
+				}
+
+				for _, element := range a {
+					var match bool
+					var err error
+					if doc, isDoc := element.(bson.M); isDoc && !isOperator(filter) {
+						match, err = matchDocument(doc, filter)
+					} else {
+						match, err = matchCondition([]interface{}{element}, filter)
+					}
+					if err != nil {
+						return false, err
+					}
+					ok = ok || match
+				}
+			}
+		default:
+			return false, fmt.Errorf("unsupported query operator %s", op)
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func anyEqual(values []interface{}, target interface{}) bool {
+	if len(values) == 0 {
+		return target == nil
+	}
+
+	for _, value := range values {
+		if valuesEqual(value, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func valuesEqual(a, b interface{}) bool {
+	if x, ok := asNumber(a); ok {
+		y, ok := asNumber(b)
+		return ok && x == y
+	}
+
+	switch a := a.(type) {
+	case bson.M:
+		b, ok := b.(bson.M)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			if other, ok := b[key]; !ok || !valuesEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case bson.A:
+		b, ok := b.(bson.A)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !valuesEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a == b
+}
+
+func asNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
+
+func sameKind(a, b interface{}) bool {
+	if _, ok := asNumber(a); ok {
+		_, ok := asNumber(b)
+		return ok
+	}
+
+	return fmt.Sprintf("%T", a) == fmt.Sprintf("%T", b)
+}
+
+func compareValues(a, b interface{}) int {
+	if x, ok := asNumber(a); ok {
+		if y, ok := asNumber(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	switch a := a.(type) {
+	case primitive.DateTime:
+		if b, ok := b.(primitive.DateTime); ok {
+			return compareValues(int64(a), int64(b))
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case primitive.ObjectID:
+		if b, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(a[:], b[:])
+		}
+	}
+
+	// Missing values sort first.
+	switch {
+	case a == nil && b != nil:
+		return -1
+	case a != nil && b == nil:
+		return 1
+	}
+
+	return 0
+}
+
+func truthy(v interface{}) bool {
+	if n, ok := asNumber(v); ok {
+		return n != 0
+	}
+
+	if b, ok := v.(bool); ok {
+		return b
+	}
+
+	return v != nil
+}
+
+// evalExpression evaluates the aggregation expressions used by $expr.
+func evalExpression(expr interface{}, doc bson.M, vars map[string]interface{}) (interface{}, error) {
+	switch expr := expr.(type) {
+	case string:
+		if strings.HasPrefix(expr, "$$") {
+			name, path, _ := strings.Cut(expr[2:], ".")
+			value := vars[name]
+			if len(path) > 0 {
+				m, _ := value.(bson.M)
+				return lookupValue(m, path), nil
+			}
+			return value, nil
+		}
+
+		if strings.HasPrefix(expr, "$") {
+			return lookupValue(doc, expr[1:]), nil
+		}
+
+		return expr, nil
+	case bson.A:
+		values := make(bson.A, len(expr))
+		for i, e := range expr {
+			value, err := evalExpression(e, doc, vars)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	case bson.M:
+		if !isOperator(expr) {
+			return expr, nil
+		}
+	default:
+		return expr, nil
+	}
+
+	m := expr.(bson.M)
+	for op, arg := range m {
+		if op == "$filter" {
+			spec, _ := arg.(bson.M)
+			input, err := evalExpression(spec["input"], doc, vars)
+			if err != nil {
+				return nil, err
+			}
+
+			name, _ := spec["as"].(string)
+			if len(name) == 0 {
+				name = "this"
+			}
+
+			filtered := bson.A{}
+			elements, _ := input.(bson.A)
+			for _, element := range elements {
+				scope := map[string]interface{}{name: element}
+				for key, value := range vars {
+					if key != name {
+						scope[key] = value
+					}
+				}
+
+				keep, err := evalExpression(spec["cond"], doc, scope)
+				if err != nil {
+					return nil, err
+				}
+				if truthy(keep) {
+					filtered = append(filtered, element)
+				}
+			}
+			return filtered, nil
+		}
+
+		value, err := evalExpression(arg, doc, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		args, _ := value.(bson.A)
+		switch op {
+		case "$size":
+			a, ok := value.(bson.A)
+			if !ok {
+				return nil, fmt.Errorf("$size requires an array, got %T", value)
+			}
+			return int32(len(a)), nil
+		case "$ifNull":
+			for _, a := range args {
+				if a != nil {
+					return a, nil
+				}
+			}
+			return nil, nil
+		case "$eq", "$ne", "$gt", "$gte", "$lt", "$lte":
+			if len(args) != 2 {
+				return nil, fmt.Errorf("%s requires two arguments", op)
+			}
+
+			c := compareValues(args[0], args[1])
+			switch op {
+			case "$eq":
+				return valuesEqual(args[0], args[1]), nil
+			case "$ne":
+				return !valuesEqual(args[0], args[1]), nil
+			case "$gt":
+				return c > 0, nil
+			case "$gte":
+				return c >= 0, nil
+			case "$lt":
+				return c < 0, nil
+			}
+			return c <= 0, nil
+		case "$and", "$or":
+			result := op == "$and"
+			for _, a := range args {
+				if truthy(a) != result {
+					return !result, nil
+				}
+			}
+			return result, nil
+		}
+
+		return nil, fmt.Errorf("unsupported expression operator %s", op)
+	}
+
+	return nil, nil
+}
+
+// applyUpdate applies the update operators to the document and reports
+// whether it changed. Replacement documents replace everything but the
+// _id.
+func applyUpdate(doc bson.M, update bson.M, inserting bool) (bool, error) {
+	before := copyValue(doc)
+
+	if !isOperator(update) {
+		id := doc["_id"]
+		for key := range doc {
+			delete(doc, key)
+		}
+		for key, value := range update {
+			doc[key] = normalize(value)
+		}
+		if id != nil {
+			doc["_id"] = id
+		}
+		return !valuesEqual(before, doc), nil
+	}
+
+	for op, arg := range update {
+		fields, _ := arg.(bson.M)
+		for path, value := range fields {
+			if strings.Contains(path, "$") {
+				return false, fmt.Errorf("unsupported positional update of %s", path)
+			}
+
+			switch op {
+			case "$set":
+				setPath(doc, path, value)
+			case "$setOnInsert":
+				if inserting {
+					setPath(doc, path, value)
+				}
+			case "$unset":
+				unsetPath(doc, path)
+			case "$inc":
+				current, _ := asNumber(lookupValue(doc, path))
+				delta, _ := asNumber(value)
+				if _, isFloat := value.(float64); isFloat {
+					setPath(doc, path, current+delta)
+				} else {
+					setPath(doc, path, int64(current+delta))
+				}
+			case "$push":
+				current, _ := lookupValue(doc, path).(bson.A)
+				items := bson.A{value}
+				var slice interface{}
+				if spec, ok := value.(bson.M); ok && isOperator(spec) {
+					items, _ = spec["$each"].(bson.A)
+					slice = spec["$slice"]
+				}
+
+				current = append(append(bson.A{}, current...), items...)
+				if n, ok := asNumber(slice); ok {
+					switch {
+					case n < 0 && int(-n) < len(current):
+						current = current[len(current)+int(n):]
+					case n >= 0 && int(n) < len(current):
+						current = current[:int(n)]
+					}
+				}
+				setPath(doc, path, current)
+			case "$pull":
+				current, _ := lookupValue(doc, path).(bson.A)
+				kept := bson.A{}
+				for _, element := range current {
+					var match bool
+					var err error
+					if m, isDoc := element.(bson.M); isDoc && !isOperator(value) {
+						filter, _ := value.(bson.M)
+						match, err = matchDocument(m, filter)
+					} else {
+						match, err = matchCondition([]interface{}{element}, value)
+					}
+					if err != nil {
+						return false, err
+					}
+					if !match {
+						kept = append(kept, element)
+					}
+				}
+				setPath(doc, path, kept)
+			default:
+				return false, fmt.Errorf("unsupported update operator %s", op)
+			}
+		}
+	}
+
+	return !valuesEqual(before, doc), nil
+}
+
+func setPath(doc bson.M, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := doc[part].(bson.M)
+		if !ok {
+			child = bson.M{}
+			doc[part] = child
+		}
+		doc = child
+	}
+
+	doc[parts[len(parts)-1]] = value
+}
+
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := doc[part].(bson.M)
+		if !ok {
+			return
+		}
+		doc = child
+	}
+
+	delete(doc, parts[len(parts)-1])
+}
@@ -0,0 +1,304 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"tc-server/config"
+	"tc-server/controller"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/oidc"
+	"testing"
+	"time"
+)
+
+// fakeProvider is a minimal OpenID Connect provider issuing ID tokens for
+// a single user.
+type fakeProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]url.Values // authorization request by issued code
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &fakeProvider{key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		// The user consents immediately.
+		query := r.URL.Query()
+		code := "code-" + query.Get("state")
+
+		p.mu.Lock()
+		p.codes[code] = query
+		p.mu.Unlock()
+
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+query.Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+
+		p.mu.Lock()
+		auth, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		if !ok || oidc.Challenge(r.PostForm.Get("code_verifier")) != auth.Get("code_challenge") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"id_token": p.idToken(t, "k1", auth.Get("client_id"), auth.Get("nonce")),
+		})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *fakeProvider) idToken(t *testing.T, kid string, audience string, nonce string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.server.URL,
+		"aud":            audience,
+		"sub":            "fake-subject",
+		"email":          "athlete@example.com",
+		"email_verified": "true",
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+// authorize follows the authorization URL and returns the issued code.
+func (p *fakeProvider) authorize(t *testing.T, authURL string) string {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location.Query().Get("code")
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider := oidc.New("fake", config.OIDCProviderConfig{
+		Issuer:      fake.server.URL,
+		ClientID:    "client",
+		RedirectURL: "http://localhost:3000/oauth/fake",
+	}, fake.server.Client())
+	ctx := context.Background()
+
+	verifier, _ := oidc.NewVerifier()
+	nonce, _ := oidc.NewState()
+	authURL, err := provider.AuthCodeURL(ctx, "state", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned error: %v", err)
+	}
+
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") != oidc.Challenge(verifier) || query.Get("nonce") != nonce {
+		t.Errorf("authorization url is missing PKCE or nonce parameters: %s", authURL)
+	}
+
+	claims, err := provider.Exchange(ctx, fake.authorize(t, authURL), verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange returned error: %v", err)
+	}
+
+	if claims.Subject != "fake-subject" || claims.Email != "athlete@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	// A code can not be redeemed with a different verifier.
+	other, _ := oidc.NewVerifier()
+	authURL, _ = provider.AuthCodeURL(ctx, "state-2", nonce, verifier)
+	if _, err := provider.Exchange(ctx, fake.authorize(t, authURL), other, nonce); err == nil {
+		t.Error("expected exchange with a different verifier to fail")
+	}
+
+	// An ID token issued for another authorization is rejected.
+	authURL, _ = provider.AuthCodeURL(ctx, "state-3", nonce, verifier)
+	if _, err := provider.Exchange(ctx, fake.authorize(t, authURL), verifier, "other-nonce"); err == nil {
+		t.Error("expected exchange with a different nonce to fail")
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	fake := newFakeProvider(t)
+	provider := oidc.New("fake", config.OIDCProviderConfig{Issuer: fake.server.URL, ClientID: "client"}, fake.server.Client())
+	ctx := context.Background()
+
+	if _, err := provider.Verify(ctx, fake.idToken(t, "k1", "client", "nonce"), "nonce"); err != nil {
+		t.Fatalf("Verify returned error: %v", err)
+	}
+
+	if _, err := provider.Verify(ctx, fake.idToken(t, "k1", "other-client", "nonce"), "nonce"); err == nil {
+		t.Error("expected token for another audience to fail")
+	}
+
+	if _, err := provider.Verify(ctx, fake.idToken(t, "unknown", "client", "nonce"), "nonce"); err == nil {
+		t.Error("expected token signed with an unknown key to fail")
+	}
+
+	forged, _ := rsa.GenerateKey(rand.Reader, 2048)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": fake.server.URL, "aud": "client", "sub": "fake-subject", "nonce": "nonce",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "k1"
+	signed, _ := token.SignedString(forged)
+	if _, err := provider.Verify(ctx, signed, "nonce"); err == nil {
+		t.Error("expected forged token to fail")
+	}
+}
+
+func TestOIDCCallbackKeepsLinksToTheirAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	stub, client := newRedisStub(t)
+	redisp := db.RedisParams{RedisClient: client}
+
+	ac := controller.AccountController{
+		GlobalController: &controller.GlobalController{
+			Config: &config.FullConfig{},
+			Redis:  client,
+			OIDC:   map[string]*oidc.Provider{"fake": oidc.New("fake", config.OIDCProviderConfig{}, nil)},
+		},
+		CollectionName: "account",
+	}
+
+	// The victim is signed in while following an authorization URL of a
+	// link the attacker started.
+	signedIn := func(ctx *gin.Context) { ctx.Set("accountId", "victim") }
+	router.POST("/oidc/:provider/callback", ac.OIDCCallback())
+	router.POST("/oidc/:provider/link/callback", signedIn, ac.LinkOIDCCallback())
+
+	for _, c := range []struct {
+		path string
+		code int
+	}{
+		{"/oidc/fake/callback", http.StatusBadRequest},
+		{"/oidc/fake/link/callback", http.StatusForbidden},
+	} {
+		state := `{"provider":"fake","account_id":"attacker"}`
+		if _, err := db.SetCacheValue(redisp, "oidc_state:state", state, 60); err != nil {
+			t.Fatalf("SetCacheValue() returned error: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"code":"code","state":"state"}`)
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, c.path, body))
+
+		if w.Code != c.code {
+			t.Errorf("%s responded %d, want %d", c.path, w.Code, c.code)
+		}
+
+		if stub.exists("oidc_state:state") {
+			t.Errorf("%s left the state behind", c.path)
+		}
+	}
+}
+
+func TestLinkOIDCRequiresReauthentication(t *testing.T) {
+	stubs := newAccountStubs(t)
+	fake := newFakeProvider(t)
+
+	gc := stubs.ac.GlobalController
+	gc.Config.OIDC.StateTTL = 10
+	gc.OIDC = map[string]*oidc.Provider{"fake": oidc.New("fake", config.OIDCProviderConfig{
+		Issuer:      fake.server.URL,
+		ClientID:    "client",
+		RedirectURL: "http://localhost:3000/oauth/fake",
+	}, fake.server.Client())}
+
+	withPassword := stubs.insertAccount(t, model.Account{Username: "alice", Password: stubs.passwordHash(t)})
+	passwordless := stubs.insertAccount(t, model.Account{Username: "bob"})
+
+	for _, c := range []struct {
+		name     string
+		account  primitive.ObjectID
+		authTime time.Time
+		body     string
+		code     int
+	}{
+		{"missing password", withPassword, time.Now(), `{}`, http.StatusUnauthorized},
+		{"wrong password", withPassword, time.Now(), `{"password":"wrong"}`, http.StatusUnauthorized},
+		{"stale sign-in", passwordless, time.Now().Add(-time.Hour), `{}`, http.StatusUnauthorized},
+		{"password", withPassword, time.Now().Add(-time.Hour), `{"password":"` + stubPassword + `"}`, http.StatusOK},
+		{"recent sign-in", passwordless, time.Now(), `{}`, http.StatusOK},
+	} {
+		router := gin.New()
+		router.POST("/oidc/:provider/link", signedIn(c.account, c.authTime), stubs.ac.LinkOIDC())
+
+		before := len(stubs.redis.keys("oidc_state:"))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/oidc/fake/link", strings.NewReader(c.body)))
+
+		if w.Code != c.code {
+			t.Errorf("LinkOIDC(%s) responded %d, want %d", c.name, w.Code, c.code)
+		}
+
+		started := len(stubs.redis.keys("oidc_state:")) > before
+		if started != (c.code == http.StatusOK) {
+			t.Errorf("LinkOIDC(%s) started a link: %v", c.name, started)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tc-server/db"
+	"tc-server/model"
+	"tc-server/request"
+	"tc-server/webauthn"
+	"testing"
+	"time"
+)
+
+func newPasskeyStubs(t *testing.T) *accountStubs {
+	stubs := newAccountStubs(t)
+
+	gc := stubs.ac.GlobalController
+	gc.Config.WebAuthn.ChallengeTTL = 5
+	gc.WebAuthn = &webauthn.RelyingParty{ID: "localhost", Name: "Training Club", Origins: []string{"http://localhost:3000"}}
+
+	return stubs
+}
+
+func TestBeginPasskeyRegistrationRequiresReauthentication(t *testing.T) {
+	stubs := newPasskeyStubs(t)
+	accountId := stubs.insertAccount(t, model.Account{Username: "alice", Password: stubs.passwordHash(t)})
+
+	router := gin.New()
+	router.POST("/passkeys/register/begin", signedIn(accountId, time.Now()), stubs.ac.BeginPasskeyRegistration())
+
+	for _, c := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{"missing password", `{}`, http.StatusUnauthorized},
+		{"wrong password", `{"password":"wrong"}`, http.StatusUnauthorized},
+		{"password", `{"password":"` + stubPassword + `"}`, http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/passkeys/register/begin", strings.NewReader(c.body)))
+
+		if w.Code != c.code {
+			t.Errorf("BeginPasskeyRegistration(%s) responded %d, want %d", c.name, w.Code, c.code)
+		}
+
+		started := len(stubs.redis.keys("passkey_register:")) > 0
+		if started != (c.code == http.StatusOK) {
+			t.Errorf("BeginPasskeyRegistration(%s) started a ceremony: %v", c.name, started)
+		}
+	}
+}
+
+func TestFinishPasskeyRegistrationStoresCredentialOnce(t *testing.T) {
+	stubs := newPasskeyStubs(t)
+	accountId := stubs.insertAccount(t, model.Account{Username: "alice"})
+
+	router := gin.New()
+	router.POST("/passkeys/register/finish", signedIn(accountId, time.Now()), stubs.ac.FinishPasskeyRegistration())
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	authenticator := newSoftAuthenticator(t, "localhost", "http://localhost:3000", key)
+	credentialId := webauthn.EncodeBase64URL(authenticator.credentialID)
+
+	challenge, _ := webauthn.NewChallenge()
+	if _, err := db.SetCacheValue(db.RedisParams{RedisClient: stubs.ac.GlobalController.Redis}, "passkey_register:"+accountId.Hex(), challenge, 60); err != nil {
+		t.Fatalf("SetCacheValue() returned error: %v", err)
+	}
+
+	clientData, attestation := authenticator.create(t, challenge)
+	body, _ := json.Marshal(request.PasskeyRegisterRequest{
+		Name: "Laptop",
+		Credential: request.PasskeyCredential{
+			ID:   credentialId,
+			Type: "public-key",
+			Response: request.PasskeyCredentialResponse{
+				ClientDataJSON:    webauthn.EncodeBase64URL(clientData),
+				AttestationObject: webauthn.EncodeBase64URL(attestation),
+			},
+		},
+	})
+
+	// A concurrent registration of the same credential completes after
+	// the duplicate lookup.
+	stubs.mongo.before("update", func() {
+		_, err := db.UpdateDocumentByFilter[model.Account](stubs.params("account"), accountId, bson.M{
+			"$push": bson.M{"passkeys": model.Passkey{ID: credentialId, Name: "Concurrent"}},
+		})
+		if err != nil {
+			t.Errorf("UpdateDocumentByFilter() returned error: %v", err)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/passkeys/register/finish", strings.NewReader(string(body))))
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("FinishPasskeyRegistration() responded %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	account, err := db.FindDocumentById[model.Account](stubs.params("account"), accountId.Hex())
+	if err != nil {
+		t.Fatalf("FindDocumentById() returned error: %v", err)
+	}
+
+	if len(account.Passkeys) != 1 {
+		t.Errorf("FinishPasskeyRegistration() stored %d passkeys, want 1", len(account.Passkeys))
+	}
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"tc-server/config"
+	"tc-server/util"
+	"testing"
+)
+
+func rejectionCodes(rejections []util.PasswordRejection) []string {
+	codes := []string{}
+	for _, r := range rejections {
+		codes = append(codes, r.Code)
+	}
+
+	return codes
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	breaches := t.TempDir()
+	sum := sha1.Sum([]byte("Tr0ub4dor&3"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	dataset := "0018A45C4D1DEF81644B54AB7F969B88D65:1\n" + hash[5:] + ":42\n"
+	if err := os.WriteFile(filepath.Join(breaches, hash[:5]), []byte(dataset), 0o644); err != nil {
+		t.Fatalf("failed to write breach dataset: %v", err)
+	}
+
+	policy, err := util.NewPasswordPolicy(&config.PasswordPolicyConfig{
+		MinLength:       8,
+		MaxLength:       64,
+		BreachDirectory: breaches,
+	})
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy() returned error: %v", err)
+	}
+
+	cases := []struct {
+		password string
+		want     []string
+	}{
+		{"correct horse battery staple", []string{}},
+		{"short", []string{util.PasswordTooShort}},
+		{strings.Repeat("x", 65), []string{util.PasswordTooLong}},
+		{"Password123", []string{util.PasswordCommon}},
+		{"my-jdoe-secret", []string{util.PasswordContainsIdentity}},
+		{"janedoe-secret", []string{util.PasswordContainsIdentity}},
+		{"Tr0ub4dor&3", []string{util.PasswordBreached}},
+		{"qwerty", []string{util.PasswordTooShort, util.PasswordCommon}},
+	}
+
+	for _, c := range cases {
+		rejections, err := policy.Validate(c.password, "jdoe", "janedoe@example.com")
+		if err != nil {
+			t.Fatalf("Validate(%q) returned error: %v", c.password, err)
+		}
+
+		got := rejectionCodes(rejections)
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("Validate(%q) == %v, want %v", c.password, got, c.want)
+		}
+	}
+
+	if count, err := policy.BreachCount("Tr0ub4dor&3"); err != nil || count != 42 {
+		t.Errorf("BreachCount() == %d, %v, want 42, nil", count, err)
+	}
+}
+
+func TestPasswordPolicyDefaults(t *testing.T) {
+	policy, err := util.NewPasswordPolicy(&config.PasswordPolicyConfig{})
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy() returned error: %v", err)
+	}
+
+	// Long passphrases are accepted and short identities are ignored.
+	rejections, err := policy.Validate("an entirely reasonable passphrase of forty", "ab")
+	if err != nil || len(rejections) != 0 {
+		t.Errorf("Validate() == %v, %v, want no rejections", rejectionCodes(rejections), err)
+	}
+
+	if _, err := util.NewPasswordPolicy(&config.PasswordPolicyConfig{MinLength: 20, MaxLength: 10}); err == nil {
+		t.Errorf("NewPasswordPolicy() accepted a min length above the max length")
+	}
+}
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"tc-server/config"
+	"tc-server/controller"
+	"tc-server/db"
+	"tc-server/model"
+	"testing"
+	"time"
+)
+
+func TestDeleteCacheSetWithMembers(t *testing.T) {
+	stub, client := newRedisStub(t)
+	redisp := db.RedisParams{RedisClient: client}
+
+	for _, token := range []string{"first", "second"} {
+		if _, err := db.SetCacheValue(redisp, "password_reset:"+token, "account", 60); err != nil {
+			t.Fatalf("SetCacheValue() returned error: %v", err)
+		}
+
+		if err := db.AddCacheSetMember(redisp, "account_password_resets:account", token, 60); err != nil {
+			t.Fatalf("AddCacheSetMember() returned error: %v", err)
+		}
+	}
+
+	if _, err := db.SetCacheValue(redisp, "password_reset:other", "other", 60); err != nil {
+		t.Fatalf("SetCacheValue() returned error: %v", err)
+	}
+
+	err := db.DeleteCacheSetWithMembers(redisp, "account_password_resets:account", "password_reset:")
+	if err != nil {
+		t.Fatalf("DeleteCacheSetWithMembers() returned error: %v", err)
+	}
+
+	for _, key := range []string{"account_password_resets:account", "password_reset:first", "password_reset:second"} {
+		if stub.exists(key) {
+			t.Errorf("DeleteCacheSetWithMembers() left %s behind", key)
+		}
+	}
+
+	if !stub.exists("password_reset:other") {
+		t.Errorf("DeleteCacheSetWithMembers() removed a token of another account")
+	}
+}
+
+func TestForgotPasswordRejectsInvalidEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	ac := controller.AccountController{
+		GlobalController: &controller.GlobalController{Config: &config.FullConfig{}},
+		CollectionName:   "account",
+	}
+	router.POST("/password/forgot", ac.ForgotPassword())
+
+	for _, body := range []string{`{"email":"not-an-email"}`, `{`} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/password/forgot", strings.NewReader(body)))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("ForgotPassword(%s) responded %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestForgotPasswordRespondsBeforeLookup(t *testing.T) {
+	stubs := newAccountStubs(t)
+	conf := stubs.ac.GlobalController.Config
+	conf.Auth.PasswordResetTTL = 30
+	conf.Auth.PasswordResetURL = "http://localhost:3000/reset"
+
+	stubs.insertAccount(t, model.Account{
+		Username: "alice",
+		Email:    model.AccountConfirmable{Value: "alice@example.com"},
+	})
+
+	router := gin.New()
+	router.POST("/password/forgot", stubs.ac.ForgotPassword())
+
+	forgot := func(email string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := strings.NewReader(`{"email":"` + email + `"}`)
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/password/forgot", body))
+		return w
+	}
+
+	// The account lookup is held until the response was written, or
+	// for a while if the response waits for it.
+	release := make(chan struct{})
+	var lookedUp atomic.Bool
+	stubs.mongo.before("find", func() {
+		select {
+		case <-release:
+		case <-time.After(time.Second):
+		}
+		lookedUp.Store(true)
+	})
+
+	known := forgot("alice@example.com")
+	if lookedUp.Load() {
+		t.Errorf("ForgotPassword() looked the address up before responding")
+	}
+	close(release)
+
+	unknown := forgot("nobody@example.com")
+	for _, w := range []*httptest.ResponseRecorder{known, unknown} {
+		if w.Code != http.StatusAccepted || w.Body.Len() > 0 {
+			t.Errorf("ForgotPassword() responded %d with %q, want %d without a body", w.Code, w.Body.String(), http.StatusAccepted)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for stubs.mongo.count("mail_outbox", bson.M{"to": "alice@example.com"}) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ForgotPassword() did not send a reset link to a known address")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := len(stubs.redis.keys("password_reset:")); n != 1 {
+		t.Errorf("ForgotPassword() issued %d reset tokens, want 1", n)
+	}
+}
+
+func TestResetPasswordRejectsRevokedToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	_, client := newRedisStub(t)
+	redisp := db.RedisParams{RedisClient: client}
+
+	ac := controller.AccountController{
+		GlobalController: &controller.GlobalController{Config: &config.FullConfig{}, Redis: client},
+		CollectionName:   "account",
+	}
+	router.POST("/password/reset", ac.ResetPassword())
+
+	if _, err := db.SetCacheValue(redisp, "password_reset:old", "account", 60); err != nil {
+		t.Fatalf("SetCacheValue() returned error: %v", err)
+	}
+
+	if err := db.AddCacheSetMember(redisp, "account_password_resets:account", "old", 60); err != nil {
+		t.Fatalf("AddCacheSetMember() returned error: %v", err)
+	}
+
+	// A newer request revokes the tokens issued before it.
+	if err := db.DeleteCacheSetWithMembers(redisp, "account_password_resets:account", "password_reset:"); err != nil {
+		t.Fatalf("DeleteCacheSetWithMembers() returned error: %v", err)
+	}
+
+	cases := []struct {
+		body string
+		code int
+	}{
+		{`{"password":"correct horse battery staple"}`, http.StatusBadRequest},
+		{`{"token":"old","password":"correct horse battery staple"}`, http.StatusBadRequest},
+		{`{"token":"unknown","password":"correct horse battery staple"}`, http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(c.body)))
+
+		if w.Code != c.code {
+			t.Errorf("ResetPassword(%s) responded %d, want %d", c.body, w.Code, c.code)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"tc-server/config"
+	"tc-server/util"
+	"testing"
+)
+
+// fastArgon2 keeps the tests quick, the parameters are far below what
+// production should use.
+var fastArgon2 = config.Argon2Config{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	hasher, err := util.NewPasswordHasher(&config.PasswordHashingConfig{Argon2: fastArgon2})
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() returned error: %v", err)
+	}
+
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("Hash() returned error: %v", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() == %q, want an argon2id PHC string", hash)
+	}
+
+	ok, rehash, err := hasher.Verify("correct horse", hash)
+	if err != nil || !ok || rehash {
+		t.Errorf("Verify() == %v, %v, %v, want true, false, nil", ok, rehash, err)
+	}
+
+	ok, _, err = hasher.Verify("wrong horse", hash)
+	if err != nil || ok {
+		t.Errorf("Verify() accepted a wrong password")
+	}
+
+	// A password longer than 72 bytes must not be truncated.
+	long := strings.Repeat("a", 80)
+	hash, err = hasher.Hash(long)
+	if err != nil {
+		t.Fatalf("Hash() returned error: %v", err)
+	}
+
+	if ok, _, _ := hasher.Verify(long[:72], hash); ok {
+		t.Errorf("Verify() accepted a truncated password")
+	}
+}
+
+func TestPasswordHasherRehash(t *testing.T) {
+	weak, err := util.NewPasswordHasher(&config.PasswordHashingConfig{Argon2: fastArgon2})
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() returned error: %v", err)
+	}
+
+	strong := fastArgon2
+	strong.Iterations = 2
+	hasher, err := util.NewPasswordHasher(&config.PasswordHashingConfig{Argon2: strong, BcryptCost: bcrypt.MinCost + 1})
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() returned error: %v", err)
+	}
+
+	hash, err := weak.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash() returned error: %v", err)
+	}
+
+	if ok, rehash, err := hasher.Verify("password", hash); err != nil || !ok || !rehash {
+		t.Errorf("Verify() == %v, %v, %v for weaker argon2id parameters, want true, true, nil", ok, rehash, err)
+	}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() returned error: %v", err)
+	}
+
+	if ok, rehash, err := hasher.Verify("password", string(legacy)); err != nil || !ok || !rehash {
+		t.Errorf("Verify() == %v, %v, %v for a bcrypt hash, want true, true, nil", ok, rehash, err)
+	}
+
+	if ok, _, err := hasher.Verify("other", string(legacy)); err != nil || ok {
+		t.Errorf("Verify() == %v, %v for a wrong password against a bcrypt hash, want false, nil", ok, err)
+	}
+
+	if _, _, err := hasher.Verify("password", "plaintext"); err != util.ErrUnknownPasswordHash {
+		t.Errorf("Verify() returned error %v for an unknown format, want ErrUnknownPasswordHash", err)
+	}
+}
+
+func TestPasswordHasherBcrypt(t *testing.T) {
+	hasher, err := util.NewPasswordHasher(&config.PasswordHashingConfig{
+		Algorithm:  util.PasswordAlgorithmBcrypt,
+		Argon2:     fastArgon2,
+		BcryptCost: bcrypt.MinCost,
+	})
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() returned error: %v", err)
+	}
+
+	hash, err := hasher.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash() returned error: %v", err)
+	}
+
+	if ok, rehash, err := hasher.Verify("password", hash); err != nil || !ok || rehash {
+		t.Errorf("Verify() == %v, %v, %v, want true, false, nil", ok, rehash, err)
+	}
+
+	if _, err := util.NewPasswordHasher(&config.PasswordHashingConfig{Algorithm: "md5"}); err == nil {
+		t.Errorf("NewPasswordHasher() accepted an unsupported algorithm")
+	}
+}
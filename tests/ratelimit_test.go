@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tc-server/config"
+	"tc-server/middleware"
+	"tc-server/server"
+	"testing"
+)
+
+func TestRateLimitClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, c := range []struct {
+		key     string
+		account string
+		prefix  string
+	}{
+		{"ip", "account", "ip:"},
+		{"account", "account", "account:account"},
+		{"account", "", "ip:"},
+	} {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(c.account) > 0 {
+			ctx.Set("accountId", c.account)
+		}
+
+		client := middleware.RateLimitClient(ctx, c.key)
+		if !strings.HasPrefix(client, c.prefix) {
+			t.Errorf("RateLimitClient(%s) = %s, want prefix %s", c.key, client, c.prefix)
+		}
+	}
+}
+
+func TestNewRateLimiterRejectsUnknownKeys(t *testing.T) {
+	for _, key := range []string{"", "api_key", "IP"} {
+		_, err := middleware.NewRateLimiter(nil, &config.RateLimitConfig{
+			Policies: map[string]config.RateLimitPolicy{"global": {Limit: 10, Window: 60, Key: key}},
+		})
+		if err == nil {
+			t.Errorf("NewRateLimiter() accepted key %q", key)
+		}
+	}
+}
+
+func TestRateLimiterPassesUnconfiguredPolicies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter, err := middleware.NewRateLimiter(nil, &config.RateLimitConfig{
+		Policies: map[string]config.RateLimitPolicy{"disabled": {Limit: 0, Window: 60, Key: "ip"}},
+	})
+	if err != nil {
+		t.Fatalf("NewRateLimiter() returned error: %v", err)
+	}
+
+	for _, name := range []string{"missing", "disabled"} {
+		router := gin.New()
+		router.GET("/", limiter.Limit(name), func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK || len(rec.Header().Get("X-RateLimit-Limit")) > 0 {
+			t.Errorf("policy %s: expected an unlimited request, got status %d", name, rec.Code)
+		}
+	}
+}
+
+func TestRateLimitClientIgnoresUntrustedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, c := range []struct {
+		proxies []string
+		client  string
+	}{
+		{nil, "ip:203.0.113.7"},
+		{[]string{"10.0.0.0/8"}, "ip:203.0.113.7"},
+		{[]string{"203.0.113.7"}, "ip:198.51.100.1"},
+	} {
+		router, err := server.NewRouter(&config.FullConfig{Gin: config.GinConfig{
+			Origins:        []string{"http://localhost"},
+			TrustedProxies: c.proxies,
+		}})
+		if err != nil {
+			t.Fatalf("NewRouter() returned error: %v", err)
+		}
+
+		router.GET("/", func(ctx *gin.Context) {
+			ctx.String(http.StatusOK, middleware.RateLimitClient(ctx, "ip"))
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.7:4321"
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Body.String() != c.client {
+			t.Errorf("trusted proxies %v: RateLimitClient() = %s, want %s", c.proxies, rec.Body.String(), c.client)
+		}
+	}
+
+	_, err := server.NewRouter(&config.FullConfig{Gin: config.GinConfig{
+		Origins:        []string{"http://localhost"},
+		TrustedProxies: []string{"proxy"},
+	}})
+	if err == nil {
+		t.Errorf("NewRouter() accepted an invalid trusted proxy")
+	}
+}
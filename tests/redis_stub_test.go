@@ -0,0 +1,271 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// redisStub is an in-memory server speaking enough of the Redis protocol
+// for the string and set commands used by the db package. Expiry is
+// recorded for TTL but not enforced.
+type redisStub struct {
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+	ttls    map[string]int
+}
+
+// newRedisStub starts a redisStub and returns a client connected to it.
+// Both are shut down when the test finishes.
+func newRedisStub(t *testing.T) (*redisStub, *redis.Client) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start redis stub: %v", err)
+	}
+
+	stub := &redisStub{
+		strings: make(map[string]string),
+		sets:    make(map[string]map[string]bool),
+		ttls:    make(map[string]int),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go stub.serve(conn)
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{Addr: listener.Addr().String(), Protocol: 2})
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = listener.Close()
+	})
+
+	return stub, client
+}
+
+// exists reports whether a string or set is stored at key.
+func (s *redisStub) exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.strings[key]
+	return ok || len(s.sets[key]) > 0
+}
+
+// ttl returns the expiry in seconds a key was stored with, -1 if it has
+// none and -2 if it does not exist.
+func (s *redisStub) ttl(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.strings[key]; !ok {
+		return -2
+	}
+
+	if ttl, ok := s.ttls[key]; ok {
+		return ttl
+	}
+
+	return -1
+}
+
+// keys returns the string keys starting with prefix.
+func (s *redisStub) keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.strings {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (s *redisStub) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	var queued [][]string
+	var multi bool
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		name := strings.ToUpper(args[0])
+		switch {
+		case name == "MULTI":
+			multi, queued = true, nil
+			_, _ = io.WriteString(conn, "+OK\r\n")
+		case name == "EXEC":
+			replies := make([]string, 0, len(queued))
+			for _, cmd := range queued {
+				replies = append(replies, s.exec(cmd))
+			}
+
+			multi = false
+			_, _ = io.WriteString(conn, fmt.Sprintf("*%d\r\n%s", len(replies), strings.Join(replies, "")))
+		case multi:
+			queued = append(queued, args)
+			_, _ = io.WriteString(conn, "+QUEUED\r\n")
+		default:
+			_, _ = io.WriteString(conn, s.exec(args))
+		}
+	}
+}
+
+func (s *redisStub) exec(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "CLIENT", "SELECT", "EXPIRE", "PEXPIRE":
+		return "+OK\r\n"
+	case "GET":
+		if v, ok := s.strings[args[1]]; ok {
+			return bulk(v)
+		}
+		return "$-1\r\n"
+	case "GETDEL":
+		v, ok := s.strings[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		delete(s.strings, args[1])
+		delete(s.ttls, args[1])
+		return bulk(v)
+	case "SET":
+		ttl := -1
+		for i, opt := range args[3:] {
+			switch strings.ToUpper(opt) {
+			case "NX":
+				if _, ok := s.strings[args[1]]; ok {
+					return "$-1\r\n"
+				}
+			case "EX":
+				ttl, _ = strconv.Atoi(args[4+i])
+			}
+		}
+		s.strings[args[1]] = args[2]
+		s.ttls[args[1]] = ttl
+		return "+OK\r\n"
+	case "INCR":
+		n, _ := strconv.Atoi(s.strings[args[1]])
+		s.strings[args[1]] = strconv.Itoa(n + 1)
+		return fmt.Sprintf(":%d\r\n", n+1)
+	case "TTL":
+		_, isString := s.strings[args[1]]
+		if !isString && len(s.sets[args[1]]) == 0 {
+			return ":-2\r\n"
+		}
+		if ttl, ok := s.ttls[args[1]]; ok {
+			return fmt.Sprintf(":%d\r\n", ttl)
+		}
+		return ":-1\r\n"
+	case "DEL":
+		var n int
+		for _, key := range args[1:] {
+			if _, ok := s.strings[key]; ok {
+				n++
+			}
+			if _, ok := s.sets[key]; ok {
+				n++
+			}
+			delete(s.strings, key)
+			delete(s.sets, key)
+			delete(s.ttls, key)
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SADD":
+		if s.sets[args[1]] == nil {
+			s.sets[args[1]] = make(map[string]bool)
+		}
+		for _, member := range args[2:] {
+			s.sets[args[1]][member] = true
+		}
+		return fmt.Sprintf(":%d\r\n", len(args)-2)
+	case "SREM":
+		for _, member := range args[2:] {
+			delete(s.sets[args[1]], member)
+		}
+		return fmt.Sprintf(":%d\r\n", len(args)-2)
+	case "SMEMBERS":
+		members := make([]string, 0, len(s.sets[args[1]]))
+		for member := range s.sets[args[1]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+
+		reply := fmt.Sprintf("*%d\r\n", len(members))
+		for _, member := range members {
+			reply += bulk(member)
+		}
+		return reply
+	}
+
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func bulk(v string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		args[i] = string(buf[:size])
+	}
+
+	return args, nil
+}
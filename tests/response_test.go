@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"tc-server/model"
+	"tc-server/response"
+	"testing"
+	"time"
+)
+
+func TestNewAccountResponse(t *testing.T) {
+	account := model.Account{
+		ID:       primitive.NewObjectID(),
+		Username: "athlete",
+		Email:    model.AccountConfirmable{Value: "athlete@example.com"},
+		Password: "$2a$08$hash",
+	}
+
+	b, err := json.Marshal(response.NewAccountResponse(account))
+	if err != nil {
+		t.Fatalf("failed to encode account response: %v", err)
+	}
+
+	if strings.Contains(string(b), "password") || strings.Contains(string(b), account.Password) {
+		t.Errorf("account response exposes the password hash: %s", b)
+	}
+
+	if strings.Contains(string(b), "confirmed_at") {
+		t.Errorf("account response includes confirmed_at for an unconfirmed email: %s", b)
+	}
+
+	if strings.Contains(string(b), "deletion") {
+		t.Errorf("account response includes deletion for an account that is not pending deletion: %s", b)
+	}
+
+	if strings.Contains(string(b), "pending_email") {
+		t.Errorf("account response includes pending_email without a pending change: %s", b)
+	}
+
+	if !strings.Contains(string(b), account.ID.Hex()) || !strings.Contains(string(b), "athlete@example.com") {
+		t.Errorf("account response is missing the account id or email: %s", b)
+	}
+}
+
+func TestNewAccountResponsePendingDeletion(t *testing.T) {
+	scheduled := time.Date(2026, 11, 17, 0, 0, 0, 0, time.UTC)
+	account := model.Account{
+		ID:       primitive.NewObjectID(),
+		Username: "athlete",
+		Deletion: &model.AccountDeletion{RequestedAt: scheduled.AddDate(0, 0, -30), ScheduledAt: scheduled},
+	}
+
+	res := response.NewAccountResponse(account)
+	if res.PendingEmail != "" {
+		t.Errorf("NewAccountResponse().PendingEmail == %q, want empty", res.PendingEmail)
+	}
+
+	if res.Deletion == nil || !res.Deletion.ScheduledAt.Equal(scheduled) {
+		t.Errorf("NewAccountResponse().Deletion == %+v, want the scheduled deletion", res.Deletion)
+	}
+}
+
+func TestNewPublicAccountResponse(t *testing.T) {
+	account := model.Account{
+		ID:       primitive.NewObjectID(),
+		Username: "athlete",
+		Email:    model.AccountConfirmable{Value: "athlete@example.com"},
+		Password: "$2a$08$hash",
+		Metadata: model.AccountMetadata{
+			Profile: model.AccountProfile{DisplayName: "Athlete"},
+		},
+	}
+
+	b, err := json.Marshal(response.NewPublicAccountResponse(account))
+	if err != nil {
+		t.Fatalf("failed to encode public account response: %v", err)
+	}
+
+	for _, private := range []string{"athlete@example.com", "password", "last_seen_at"} {
+		if strings.Contains(string(b), private) {
+			t.Errorf("public account response exposes %q: %s", private, b)
+		}
+	}
+
+	if !strings.Contains(string(b), `"display_name":"Athlete"`) {
+		t.Errorf("public account response is missing the display name: %s", b)
+	}
+}
+
+func TestNewDataExportResponse(t *testing.T) {
+	export := model.DataExport{
+		ID:          primitive.NewObjectID(),
+		Status:      model.ExportStatusPending,
+		BlobKey:     "exports/secret.zip",
+		RequestedAt: time.Now(),
+	}
+
+	b, err := json.Marshal(response.NewDataExportResponse(export, ""))
+	if err != nil {
+		t.Fatalf("failed to encode data export response: %v", err)
+	}
+
+	for _, field := range []string{"completed_at", "expires_at", "download_url", export.BlobKey} {
+		if strings.Contains(string(b), field) {
+			t.Errorf("pending data export response includes %q: %s", field, b)
+		}
+	}
+}
+
+func TestNewAccountResponsePendingEmail(t *testing.T) {
+	account := model.Account{
+		ID:           primitive.NewObjectID(),
+		Email:        model.AccountConfirmable{Value: "old@example.com", Confirmed: true},
+		PendingEmail: &model.AccountPendingEmail{Value: "new@example.com"},
+	}
+
+	res := response.NewAccountResponse(account)
+	if res.Email.Value != "old@example.com" || res.PendingEmail != "new@example.com" {
+		t.Errorf("NewAccountResponse() == %+v, want the current and pending address", res)
+	}
+}
+
+func TestUsernameRedirectResponse(t *testing.T) {
+	b, err := json.Marshal(response.UsernameRedirectResponse{ID: "abc", Username: "athlete"})
+	if err != nil {
+		t.Fatalf("failed to encode username redirect response: %v", err)
+	}
+
+	if string(b) != `{"id":"abc","username":"athlete"}` {
+		t.Errorf("username redirect response == %s", b)
+	}
+}
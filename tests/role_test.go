@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"tc-server/middleware"
+	"tc-server/model"
+	"testing"
+)
+
+func TestScopesForRoles(t *testing.T) {
+	scopes := model.ScopesForRoles([]string{model.RoleMember, model.RoleStaff, model.RoleMember})
+	expected := []string{"account:read", "account:write", "staff:accounts", "staff:moderation"}
+	if !slices.Equal(scopes, expected) {
+		t.Errorf("expected scopes %v, got %v", expected, scopes)
+	}
+
+	if model.ScopesForRoles(nil) != nil {
+		t.Error("expected no scopes without roles")
+	}
+}
+
+func TestEffectiveRoles(t *testing.T) {
+	if roles := (model.Account{}).EffectiveRoles(); !slices.Equal(roles, []string{model.RoleMember}) {
+		t.Errorf("expected accounts without roles to be members, got %v", roles)
+	}
+}
+
+func TestRequireRoleAndScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		roles   []string
+		guard   gin.HandlerFunc
+		expects int
+	}{
+		{"role granted", []string{model.RoleMember, model.RoleStaff}, middleware.RequireRole(model.RoleStaff), http.StatusOK},
+		{"any role granted", []string{model.RoleCoach}, middleware.RequireRole(model.RoleStaff, model.RoleCoach), http.StatusOK},
+		{"role missing", []string{model.RoleMember}, middleware.RequireRole(model.RoleStaff), http.StatusForbidden},
+		{"no roles", nil, middleware.RequireRole(model.RoleMember), http.StatusForbidden},
+		{"scopes granted", []string{model.RoleCoach}, middleware.RequireScope("athletes:read", "athletes:write"), http.StatusOK},
+		{"one scope missing", []string{model.RoleCoach}, middleware.RequireScope("athletes:read", "club:manage"), http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		router := gin.New()
+		router.GET("/", func(ctx *gin.Context) {
+			// Mirrors the values set by middleware.Authorize.
+			ctx.Set("roles", test.roles)
+			ctx.Set("scopes", model.ScopesForRoles(test.roles))
+		}, test.guard, func(ctx *gin.Context) {
+			ctx.Status(http.StatusOK)
+		})
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != test.expects {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expects, rec.Code)
+		}
+	}
+}
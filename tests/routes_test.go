@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"github.com/gin-gonic/gin"
+	"tc-server/config"
+	"tc-server/controller"
+	"tc-server/middleware"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	gc := controller.GlobalController{Config: &config.FullConfig{}, Limiter: &middleware.RateLimiter{}}
+	gc.ApplyAccountRoutes(router)
+	gc.ApplyStaffRoutes(router)
+	gc.ApplyWellKnownRoutes(router)
+
+	registered := make(map[string]bool)
+	for _, route := range router.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+
+	expected := []string{
+		"GET /v1/account/availability/:key/:value",
+		"GET /v1/account/confirm/:confirmId",
+		"POST /v1/account/",
+		"POST /v1/account/login",
+		"POST /v1/account/login/mfa",
+		"POST /v1/account/passkeys/login/begin",
+		"POST /v1/account/passkeys/login/finish",
+		"POST /v1/account/oidc/:provider/authorize",
+		"POST /v1/account/oidc/:provider/callback",
+		"POST /v1/account/refresh",
+		"POST /v1/account/logout",
+		"POST /v1/account/password/forgot",
+		"POST /v1/account/password/reset",
+		"GET /v1/account/exports/:exportId/download",
+		"POST /v1/account/logout/all",
+		"POST /v1/account/deletion",
+		"DELETE /v1/account/deletion",
+		"POST /v1/account/exports",
+		"GET /v1/account/exports",
+		"GET /v1/account/sessions",
+		"DELETE /v1/account/sessions/:sessionId",
+		"POST /v1/account/confirm/resend",
+		"PUT /v1/account/email",
+		"PUT /v1/account/username",
+		"GET /v1/account/",
+		"PATCH /v1/account/profile",
+		"POST /v1/account/profile/avatar",
+		"POST /v1/account/mfa/totp",
+		"POST /v1/account/mfa/totp/verify",
+		"DELETE /v1/account/mfa/totp",
+		"POST /v1/account/mfa/recovery",
+		"GET /v1/account/passkeys",
+		"POST /v1/account/passkeys/register/begin",
+		"POST /v1/account/passkeys/register/finish",
+		"DELETE /v1/account/passkeys/:credentialId",
+		"POST /v1/account/oidc/:provider/link",
+		"POST /v1/account/oidc/:provider/link/callback",
+		"DELETE /v1/account/oidc/:provider",
+		"GET /v1/account/:key/:value",
+		"GET /v1/staff/account/:accountId/audit",
+		"PUT /v1/staff/account/:accountId/roles",
+		"GET /v1/staff/account/:accountId/usernames",
+		"GET /v1/staff/usernames/:username",
+		"GET /.well-known/jwks.json",
+	}
+
+	for _, route := range expected {
+		if !registered[route] {
+			t.Errorf("route %q is not registered", route)
+		}
+	}
+}
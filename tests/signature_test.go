@@ -0,0 +1,33 @@
+package tests
+
+import (
+	"tc-server/util"
+	"testing"
+	"time"
+)
+
+func TestSignExpiring(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	signature := util.SignExpiring("secret", "export", expires)
+
+	if !util.VerifyExpiring("secret", "export", expires, signature) {
+		t.Errorf("VerifyExpiring() rejected a valid signature")
+	}
+
+	if util.VerifyExpiring("secret", "other", expires, signature) {
+		t.Errorf("VerifyExpiring() accepted a signature for a different value")
+	}
+
+	if util.VerifyExpiring("secret", "export", expires.Add(time.Hour), signature) {
+		t.Errorf("VerifyExpiring() accepted a signature with an extended expiry")
+	}
+
+	if util.VerifyExpiring("other", "export", expires, signature) {
+		t.Errorf("VerifyExpiring() accepted a signature made with a different secret")
+	}
+
+	past := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if util.VerifyExpiring("secret", "export", past, util.SignExpiring("secret", "export", past)) {
+		t.Errorf("VerifyExpiring() accepted an expired signature")
+	}
+}
@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"tc-server/config"
+	"tc-server/storage"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store := &storage.LocalStore{Directory: t.TempDir(), BaseURL: "http://localhost/blobs/"}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "avatars/a/64.png", strings.NewReader("image"), 5, "image/png"); err != nil {
+		t.Fatalf("Put() returned error: %v", err)
+	}
+
+	r, err := store.Get(ctx, "avatars/a/64.png")
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+
+	if string(b) != "image" {
+		t.Errorf("Get() == %q, want %q", b, "image")
+	}
+
+	if url := store.URL("avatars/a/64.png"); url != "http://localhost/blobs/avatars/a/64.png" {
+		t.Errorf("URL() == %q", url)
+	}
+
+	if err := store.Delete(ctx, "avatars/a/64.png"); err != nil {
+		t.Fatalf("Delete() returned error: %v", err)
+	}
+
+	if _, err := store.Get(ctx, "avatars/a/64.png"); err != storage.ErrNotFound {
+		t.Errorf("Get() after Delete() == %v, want ErrNotFound", err)
+	}
+
+	if err := store.Put(ctx, "../escape", strings.NewReader("x"), 1, "text/plain"); err == nil {
+		t.Errorf("Put() accepted a key escaping the store directory")
+	}
+}
+
+// fakeS3 is a minimal path style S3 stand-in which requires signed requests.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/") || !strings.Contains(auth, "Signature=") ||
+		len(r.Header.Get("X-Amz-Date")) == 0 || len(r.Header.Get("X-Amz-Content-Sha256")) != 64 {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = b
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(b)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store := &storage.S3Store{
+		Endpoint:  server.URL,
+		Region:    "us-east-1",
+		Bucket:    "bucket",
+		AccessKey: "access",
+		SecretKey: "secret",
+		PathStyle: true,
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "avatars/a/64.png", bytes.NewReader([]byte("image")), 5, "image/png"); err != nil {
+		t.Fatalf("Put() returned error: %v", err)
+	}
+
+	if _, ok := fake.objects["/bucket/avatars/a/64.png"]; !ok {
+		t.Fatalf("Put() did not store the object under the bucket path")
+	}
+
+	r, err := store.Get(ctx, "avatars/a/64.png")
+	if err != nil {
+		t.Fatalf("Get() returned error: %v", err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+
+	if string(b) != "image" {
+		t.Errorf("Get() == %q, want %q", b, "image")
+	}
+
+	if err := store.Delete(ctx, "avatars/a/64.png"); err != nil {
+		t.Fatalf("Delete() returned error: %v", err)
+	}
+
+	if _, err := store.Get(ctx, "avatars/a/64.png"); err != storage.ErrNotFound {
+		t.Errorf("Get() after Delete() == %v, want ErrNotFound", err)
+	}
+
+	if url := store.URL("avatars/a/64.png"); url != server.URL+"/bucket/avatars/a/64.png" {
+		t.Errorf("URL() == %q", url)
+	}
+}
+
+func TestNewPrivate(t *testing.T) {
+	public := &config.StorageConfig{
+		Driver: "local",
+		Local:  config.LocalStorageConfig{Directory: "data/blobs", Route: "/blobs"},
+		S3:     config.S3StorageConfig{Endpoint: "http://minio:9000", Bucket: "public"},
+	}
+
+	for _, c := range []struct {
+		name  string
+		conf  config.StorageConfig
+		valid bool
+	}{
+		{"separate directory", config.StorageConfig{Driver: "local", Local: config.LocalStorageConfig{Directory: "data/exports"}}, true},
+		{"default driver", config.StorageConfig{Local: config.LocalStorageConfig{Directory: "data/exports"}}, true},
+		{"missing directory", config.StorageConfig{Driver: "local"}, false},
+		{"served route", config.StorageConfig{Driver: "local", Local: config.LocalStorageConfig{Directory: "data/exports", Route: "/exports"}}, false},
+		{"public directory", config.StorageConfig{Driver: "local", Local: config.LocalStorageConfig{Directory: "data/blobs"}}, false},
+		{"inside public directory", config.StorageConfig{Driver: "local", Local: config.LocalStorageConfig{Directory: "data/blobs/exports"}}, false},
+		{"sibling of public directory", config.StorageConfig{Driver: "local", Local: config.LocalStorageConfig{Directory: "data/blobs-private"}}, true},
+		{"private bucket", config.StorageConfig{Driver: "s3", S3: config.S3StorageConfig{Endpoint: "http://minio:9000", Bucket: "exports"}}, true},
+	} {
+		_, err := storage.NewPrivate(&c.conf, public)
+		if (err == nil) != c.valid {
+			t.Errorf("NewPrivate(%s) returned error %v, want valid %v", c.name, err, c.valid)
+		}
+	}
+
+	public.Driver = "s3"
+	shared := config.StorageConfig{Driver: "s3", S3: config.S3StorageConfig{Endpoint: "http://minio:9000", Bucket: "public"}}
+	if _, err := storage.NewPrivate(&shared, public); err == nil {
+		t.Errorf("NewPrivate() accepted the public bucket")
+	}
+
+	inside := config.StorageConfig{Driver: "local", Local: config.LocalStorageConfig{Directory: "data/blobs"}}
+	if _, err := storage.NewPrivate(&inside, public); err != nil {
+		t.Errorf("NewPrivate() rejected a directory that is not served: %v", err)
+	}
+}
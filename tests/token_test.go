@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"tc-server/util"
+	"testing"
+	"time"
+)
+
+func TestGenerateTokenWithClaims(t *testing.T) {
+	key := util.NewHMACTokenKey("refresh", "secret")
+	claims := util.Claims{AccountID: "account", FamilyID: "family"}
+
+	first, err := util.GenerateTokenWithClaims(claims, key, 10)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClaims() returned error: %v", err)
+	}
+
+	second, err := util.GenerateTokenWithClaims(claims, key, 10)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClaims() returned error: %v", err)
+	}
+
+	if first == second {
+		t.Errorf("GenerateTokenWithClaims() produced identical tokens for identical claims")
+	}
+
+	parsed, err := util.ValidateTokenClaims(first, key)
+	if err != nil {
+		t.Fatalf("ValidateTokenClaims() returned error: %v", err)
+	}
+
+	if parsed.AccountID != "account" || parsed.FamilyID != "family" || len(parsed.ID) == 0 {
+		t.Errorf("ValidateTokenClaims() == %+v, want account/family claims with an ID", parsed)
+	}
+
+	if _, err := util.ValidateTokenClaims(first, util.NewHMACTokenKey("refresh", "other")); err == nil {
+		t.Errorf("ValidateTokenClaims() accepted a token signed with a different secret")
+	}
+
+	if _, err := util.ValidateTokenClaims(first, util.NewHMACTokenKey("access", "secret")); err == nil {
+		t.Errorf("ValidateTokenClaims() accepted a token with a different kid")
+	}
+}
+
+func TestGenerateTokenWithClaimsPresetID(t *testing.T) {
+	key := util.NewHMACTokenKey("refresh", "secret")
+	claims := util.Claims{AccountID: "account", RegisteredClaims: jwt.RegisteredClaims{ID: "preset"}}
+
+	token, err := util.GenerateTokenWithClaims(claims, key, 10)
+	if err != nil {
+		t.Fatalf("GenerateTokenWithClaims() returned error: %v", err)
+	}
+
+	parsed, err := util.ValidateTokenClaims(token, key)
+	if err != nil {
+		t.Fatalf("ValidateTokenClaims() returned error: %v", err)
+	}
+
+	if parsed.ID != "preset" {
+		t.Errorf("ValidateTokenClaims().ID == %q, want %q", parsed.ID, "preset")
+	}
+}
+
+func pemKey(t *testing.T, key interface{}) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestAsymmetricTokenKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	cases := []struct {
+		key interface{}
+		alg string
+		kty string
+	}{
+		{rsaKey, "RS256", "RSA"},
+		{edKey, "EdDSA", "OKP"},
+		{ecKey, "ES256", "EC"},
+	}
+
+	for _, c := range cases {
+		key, err := util.ParseTokenKey("kid-"+c.alg, pemKey(t, c.key))
+		if err != nil {
+			t.Fatalf("ParseTokenKey(%s) returned error: %v", c.alg, err)
+		}
+
+		token, err := util.GenerateToken("account", key, 10)
+		if err != nil {
+			t.Fatalf("GenerateToken(%s) returned error: %v", c.alg, err)
+		}
+
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		if err != nil || parsed.Header["kid"] != "kid-"+c.alg || parsed.Header["alg"] != c.alg {
+			t.Errorf("GenerateToken(%s) header == %v, want kid and alg", c.alg, parsed.Header)
+		}
+
+		claims, err := util.ValidateTokenClaims(token, key)
+		if err != nil || claims.AccountID != "account" {
+			t.Errorf("ValidateTokenClaims(%s) == %v, %v", c.alg, claims, err)
+		}
+
+		jwk, ok := key.JWK()
+		if !ok || jwk["kty"] != c.kty || jwk["kid"] != "kid-"+c.alg || jwk["alg"] != c.alg {
+			t.Errorf("JWK(%s) == %v", c.alg, jwk)
+		}
+	}
+
+	if _, ok := util.NewHMACTokenKey("access", "secret").JWK(); ok {
+		t.Errorf("JWK() published an HMAC secret")
+	}
+}
+
+func TestTokenAlgorithmConfusion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	key, err := util.ParseTokenKey("access", pemKey(t, rsaKey))
+	if err != nil {
+		t.Fatalf("ParseTokenKey() returned error: %v", err)
+	}
+
+	// A token signed with HS256 using the public key as the secret must
+	// not be accepted by an RS256 key.
+	public, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged, err := util.GenerateToken("account", util.NewHMACTokenKey("access", string(public)), 10)
+	if err != nil {
+		t.Fatalf("GenerateToken() returned error: %v", err)
+	}
+
+	if _, err := util.ValidateTokenClaims(forged, key); err == nil {
+		t.Errorf("ValidateTokenClaims() accepted an HS256 token for an RS256 key")
+	}
+}
+
+func TestAuthenticatedWithin(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		authTime time.Time
+		want     bool
+	}{
+		{now, true},
+		{now.Add(-4 * time.Minute), true},
+		{now.Add(-5 * time.Minute), true},
+		{now.Add(-6 * time.Minute), false},
+		{now.Add(time.Minute), false},
+		{time.Unix(0, 0), false},
+		{time.Time{}, false},
+	} {
+		if got := util.AuthenticatedWithin(c.authTime, now, 5*time.Minute); got != c.want {
+			t.Errorf("AuthenticatedWithin(%v) = %v, want %v", c.authTime, got, c.want)
+		}
+	}
+}
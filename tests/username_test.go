@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"tc-server/db"
+	"tc-server/model"
+	"testing"
+	"time"
+)
+
+func TestUsernameChangeWait(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	for _, c := range []struct {
+		name      string
+		changedAt []time.Time
+		limit     int
+		want      time.Duration
+	}{
+		{"unlimited", []time.Time{now, now, now}, 0, 0},
+		{"no changes", nil, 2, 0},
+		{"below limit", []time.Time{now.Add(-day)}, 2, 0},
+		{"at limit", []time.Time{now.Add(-day), now.Add(-10 * day)}, 2, 20 * day},
+		{"unordered", []time.Time{now.Add(-10 * day), now.Add(-day)}, 2, 20 * day},
+		{"outside period", []time.Time{now.Add(-day), now.Add(-31 * day)}, 2, 0},
+		{"over limit", []time.Time{now.Add(-day), now.Add(-5 * day), now.Add(-10 * day)}, 2, 25 * day},
+		{"single change", []time.Time{now.Add(-29 * day)}, 1, day},
+	} {
+		got := model.UsernameChangeWait(c.changedAt, now, c.limit, 30)
+		if got != c.want {
+			t.Errorf("UsernameChangeWait(%s) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestGetAccountByKeyValueRedirectsPreviousUsername(t *testing.T) {
+	stubs := newAccountStubs(t)
+	now := time.Now()
+
+	aliceId := stubs.insertAccount(t, model.Account{Username: "alice"})
+	carolId := stubs.insertAccount(t, model.Account{
+		Username: "carol",
+		Deletion: &model.AccountDeletion{RequestedAt: now, ScheduledAt: now.Add(24 * time.Hour)},
+	})
+
+	_, err := db.InsertDocument(stubs.params("username_history"), model.UsernameChange{
+		AccountID:   carolId,
+		OldUsername: "caroline",
+		NewUsername: "carol",
+		ChangedAt:   now,
+	})
+	if err != nil {
+		t.Fatalf("InsertDocument() returned error: %v", err)
+	}
+
+	router := gin.New()
+	router.PUT("/username", signedIn(aliceId, now), stubs.ac.ChangeUsername())
+	router.GET("/:key/:value", signedIn(aliceId, now), stubs.ac.GetAccountByKeyValue())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/username", strings.NewReader(`{"username":"alicia"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("ChangeUsername() responded %d, want %d", w.Code, http.StatusOK)
+	}
+
+	for _, c := range []struct {
+		username string
+		code     int
+		location string
+	}{
+		{"alice", http.StatusFound, "/v1/account/username/alicia"},
+		{"alicia", http.StatusOK, ""},
+		{"caroline", http.StatusNotFound, ""},
+		{"nobody", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/username/"+c.username, nil))
+
+		if w.Code != c.code || w.Header().Get("Location") != c.location {
+			t.Errorf("GetAccountByKeyValue(%s) responded %d to %q, want %d to %q", c.username, w.Code, w.Header().Get("Location"), c.code, c.location)
+		}
+	}
+}
+
+func TestChangeUsernameLimitHoldsUnderConcurrency(t *testing.T) {
+	stubs := newAccountStubs(t)
+	conf := stubs.ac.GlobalController.Config
+	conf.Account.UsernameChangeLimit = 1
+	conf.Account.UsernameChangePeriod = 30
+
+	now := time.Now()
+	accountId := stubs.insertAccount(t, model.Account{Username: "alice"})
+
+	router := gin.New()
+	router.PUT("/username", signedIn(accountId, now), stubs.ac.ChangeUsername())
+
+	// A concurrent change is recorded after the limit was checked, so
+	// only the update itself can refuse this one.
+	stubs.mongo.before("update", func() {
+		_, err := db.UpdateDocumentByFilter[model.Account](stubs.params("account"), accountId, bson.M{
+			"$push": bson.M{"username_changed_at": time.Now()},
+		})
+		if err != nil {
+			t.Errorf("UpdateDocumentByFilter() returned error: %v", err)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/username", strings.NewReader(`{"username":"alicia"}`)))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("ChangeUsername() responded %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter <= 29*24*60*60 {
+		t.Errorf("ChangeUsername() responded with Retry-After %q, want about 30 days", w.Header().Get("Retry-After"))
+	}
+
+	if n := stubs.mongo.count("account", bson.M{"username": "alice"}); n != 1 {
+		t.Errorf("ChangeUsername() changed the username past the limit")
+	}
+}
@@ -37,6 +37,8 @@ func TestValidateEmail(t *testing.T) {
 		{"firstname.lastname@example.com", true},
 		{"email@subdomain.example.com", true},
 		{"email@123.123.123.123", true},
+		{"email@example.c", false},
+		{"email@example", false},
 		{"email@[123.123.123.123]", false}, // This pattern is technically valid but not covered by our regex
 		{"plainaddress", false},
 		{"@no-local-part.com", false},
@@ -51,3 +53,28 @@ func TestValidateEmail(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateDisplayName(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{"Jane Doe", true},
+		{"José O'Neil-Smith", true},
+		{"Coach_K.", true},
+		{"Scunthorpe United", true},
+		{"", false},
+		{" Jane", false},
+		{"Jane <script>", false},
+		{"This display name is far too long to be accepted", false},
+		{"sh1t happens", false},
+		{"F U C K", false},
+	}
+
+	for _, c := range cases {
+		result := util.ValidateDisplayName(c.name)
+		if result != c.valid {
+			t.Errorf("ValidateDisplayName(%q) == %v, want %v", c.name, result, c.valid)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/ugorji/go/codec"
+	"tc-server/webauthn"
+	"testing"
+)
+
+// softAuthenticator is a software WebAuthn authenticator holding a single
+// credential, used to perform ceremonies without a browser.
+type softAuthenticator struct {
+	rpID         string
+	origin       string
+	credentialID []byte
+	signer       crypto.Signer
+	signCount    uint32
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T, rpID string, origin string, signer crypto.Signer) *softAuthenticator {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// User present and user verified.
+	return &softAuthenticator{rpID: rpID, origin: origin, credentialID: id, signer: signer, flags: 0x05}
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge string) []byte {
+	b, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": a.origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func (a *softAuthenticator) authData(extra []byte, flags byte) []byte {
+	hash := sha256.Sum256([]byte(a.rpID))
+	data := append(hash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, extra...)
+}
+
+func (a *softAuthenticator) coseKey(t *testing.T) []byte {
+	var params map[int]interface{}
+	switch key := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		params = map[int]interface{}{1: 2, 3: -7, -1: 1, -2: key.X.FillBytes(make([]byte, 32)), -3: key.Y.FillBytes(make([]byte, 32))}
+	case ed25519.PublicKey:
+		params = map[int]interface{}{1: 1, 3: -8, -1: 6, -2: []byte(key)}
+	}
+
+	var b []byte
+	if err := codec.NewEncoderBytes(&b, &codec.CborHandle{}).Encode(params); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// create performs a registration ceremony, returning the client data and
+// attestation object.
+func (a *softAuthenticator) create(t *testing.T, challenge string) ([]byte, []byte) {
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.coseKey(t)...)
+
+	var attestation []byte
+	err := codec.NewEncoderBytes(&attestation, &codec.CborHandle{}).Encode(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(attested, a.flags|0x40),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.clientData(t, "webauthn.create", challenge), attestation
+}
+
+// get performs an assertion ceremony, returning the client data,
+// authenticator data and signature.
+func (a *softAuthenticator) get(t *testing.T, challenge string) ([]byte, []byte, []byte) {
+	clientData := a.clientData(t, "webauthn.get", challenge)
+	authData := a.authData(nil, a.flags)
+
+	hash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), hash[:]...)
+
+	var signature []byte
+	var err error
+	if _, ok := a.signer.(ed25519.PrivateKey); ok {
+		signature, err = a.signer.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(signed)
+		signature, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return clientData, authData, signature
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	rp := &webauthn.RelyingParty{ID: "localhost", Name: "Training Club", Origins: []string{"http://localhost:3000"}}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for name, signer := range map[string]crypto.Signer{"ES256": ecKey, "EdDSA": edKey} {
+		t.Run(name, func(t *testing.T) {
+			authenticator := newSoftAuthenticator(t, "localhost", "http://localhost:3000", signer)
+
+			challenge, _ := webauthn.NewChallenge()
+			clientData, attestation := authenticator.create(t, challenge)
+			credential, err := rp.VerifyRegistration(challenge, clientData, attestation)
+			if err != nil {
+				t.Fatalf("VerifyRegistration returned error: %v", err)
+			}
+
+			if string(credential.ID) != string(authenticator.credentialID) {
+				t.Errorf("credential id mismatch")
+			}
+
+			// Login with an increasing counter.
+			authenticator.signCount = 1
+			challenge, _ = webauthn.NewChallenge()
+			clientData, authData, signature := authenticator.get(t, challenge)
+			count, err := rp.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount, clientData, authData, signature)
+			if err != nil {
+				t.Fatalf("VerifyAssertion returned error: %v", err)
+			}
+
+			if count != 1 {
+				t.Errorf("expected sign count 1, got %d", count)
+			}
+
+			// A clone replays the same counter.
+			challenge, _ = webauthn.NewChallenge()
+			clientData, authData, signature = authenticator.get(t, challenge)
+			_, err = rp.VerifyAssertion(challenge, credential.PublicKey, count, clientData, authData, signature)
+			if !errors.Is(err, webauthn.ErrCloned) {
+				t.Errorf("expected ErrCloned, got %v", err)
+			}
+
+			// A different challenge must be rejected.
+			other, _ := webauthn.NewChallenge()
+			authenticator.signCount = 2
+			clientData, authData, signature = authenticator.get(t, challenge)
+			if _, err := rp.VerifyAssertion(other, credential.PublicKey, count, clientData, authData, signature); err == nil {
+				t.Error("expected challenge mismatch to fail")
+			}
+
+			// A tampered signature must be rejected.
+			signature[len(signature)-1] ^= 0xff
+			if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, count, clientData, authData, signature); err == nil {
+				t.Error("expected tampered signature to fail")
+			}
+		})
+	}
+}
+
+func TestWebAuthnRejectsForeignRelyingParty(t *testing.T) {
+	rp := &webauthn.RelyingParty{ID: "localhost", Origins: []string{"http://localhost:3000"}}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	for name, authenticator := range map[string]*softAuthenticator{
+		"origin": newSoftAuthenticator(t, "localhost", "https://evil.example", key),
+		"rp id":  newSoftAuthenticator(t, "evil.example", "http://localhost:3000", key),
+	} {
+		challenge, _ := webauthn.NewChallenge()
+		clientData, attestation := authenticator.create(t, challenge)
+		if _, err := rp.VerifyRegistration(challenge, clientData, attestation); err == nil {
+			t.Errorf("expected registration with foreign %s to fail", name)
+		}
+	}
+
+	unverified := newSoftAuthenticator(t, "localhost", "http://localhost:3000", key)
+	unverified.flags = 0x01
+	challenge, _ := webauthn.NewChallenge()
+	clientData, attestation := unverified.create(t, challenge)
+	if _, err := rp.VerifyRegistration(challenge, clientData, attestation); err == nil {
+		t.Error("expected registration without user verification to fail")
+	}
+}
+
+func TestWebAuthnSyncedPasskeyCounter(t *testing.T) {
+	rp := &webauthn.RelyingParty{ID: "localhost", Origins: []string{"http://localhost:3000"}}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	authenticator := newSoftAuthenticator(t, "localhost", "http://localhost:3000", key)
+
+	challenge, _ := webauthn.NewChallenge()
+	clientData, attestation := authenticator.create(t, challenge)
+	credential, err := rp.VerifyRegistration(challenge, clientData, attestation)
+	if err != nil {
+		t.Fatalf("VerifyRegistration returned error: %v", err)
+	}
+
+	// Synced passkeys never increase their counter.
+	for i := 0; i < 2; i++ {
+		challenge, _ = webauthn.NewChallenge()
+		clientData, authData, signature := authenticator.get(t, challenge)
+		if _, err := rp.VerifyAssertion(challenge, credential.PublicKey, 0, clientData, authData, signature); err != nil {
+			t.Fatalf("VerifyAssertion returned error: %v", err)
+		}
+	}
+}
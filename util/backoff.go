@@ -0,0 +1,22 @@
+package util
+
+import "time"
+
+// Backoff returns the base duration doubled for every step past the
+// first, capped at max. Steps below one return zero.
+func Backoff(base time.Duration, step int, max time.Duration) time.Duration {
+	if step < 1 {
+		return 0
+	}
+
+	backoff := base
+	for i := 1; i < step && backoff < max; i++ {
+		backoff *= 2
+	}
+
+	if backoff > max {
+		return max
+	}
+
+	return backoff
+}
@@ -0,0 +1,54 @@
+package util
+
+import "strings"
+
+var (
+	// browsers is ordered so that browsers embedding another browser's
+	// token in their user agent are matched first.
+	browsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+
+	platforms = []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DeviceName returns a short human readable description of the device
+// sending the provided user agent, such as "Firefox on Windows".
+func DeviceName(userAgent string) string {
+	var browser, platform string
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, p := range platforms {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+
+	switch {
+	case len(browser) > 0 && len(platform) > 0:
+		return browser + " on " + platform
+	case len(browser) > 0:
+		return browser
+	case len(platform) > 0:
+		return platform
+	}
+
+	return "Unknown device"
+}
@@ -9,3 +9,10 @@ import "github.com/gin-gonic/gin"
 func CreateError(ctx *gin.Context, code int, message string) {
 	ctx.AbortWithStatusJSON(code, gin.H{"message": message})
 }
+
+// CreateErrorWithDetails behaves like CreateError but attaches details
+// the client can act on, such as the individual reasons a value was
+// rejected.
+func CreateErrorWithDetails(ctx *gin.Context, code int, message string, details any) {
+	ctx.AbortWithStatusJSON(code, gin.H{"message": message, "details": details})
+}
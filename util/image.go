@@ -0,0 +1,186 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// maxImagePixels bounds the dimensions of decoded images to protect
+// against decompression bombs.
+const maxImagePixels = 25_000_000
+
+// CreateThumbnails decodes an image and produces a square, center
+// cropped rendition for every requested edge length. Renditions are
+// re-encoded from raw pixels, which drops EXIF and any other metadata of
+// the original, after applying the EXIF orientation of JPEG sources.
+// JPEG sources produce JPEG renditions, anything else produces PNG.
+func CreateThumbnails(b []byte, sizes []int) (map[int][]byte, string, error) {
+	conf, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if conf.Width*conf.Height > maxImagePixels {
+		return nil, "", fmt.Errorf("image dimensions %dx%d exceed limit", conf.Width, conf.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", err
+	}
+
+	orientation := 1
+	contentType := "image/png"
+	if format == "jpeg" {
+		orientation = jpegOrientation(b)
+		contentType = "image/jpeg"
+	}
+
+	thumbnails := make(map[int][]byte, len(sizes))
+	for _, size := range sizes {
+		// Center cropping commutes with the orientation transforms, so
+		// orienting the small rendition is equivalent and much cheaper.
+		thumb := orient(squareThumbnail(img, size), orientation)
+
+		var buf bytes.Buffer
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, thumb)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		thumbnails[size] = buf.Bytes()
+	}
+
+	return thumbnails, contentType, nil
+}
+
+// squareThumbnail crops the largest centered square from the image and
+// scales it to size x size pixels.
+func squareThumbnail(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	edge := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-edge)/2
+	y := bounds.Min.Y + (bounds.Dy()-edge)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, image.Rect(x, y, x+edge, y+edge), draw.Src, nil)
+	return dst
+}
+
+// orient applies an EXIF orientation (1-8) to a square image.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	n := img.Bounds().Dx()
+	dst := image.NewRGBA(img.Bounds())
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = n - 1 - x
+			case 3:
+				sx, sy = n-1-x, n-1-y
+			case 4:
+				sy = n - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, n-1-x
+			case 7:
+				sx, sy = n-1-y, n-1-x
+			case 8:
+				sx, sy = n-1-y, x
+			}
+			dst.SetRGBA(x, y, img.RGBAAt(sx, sy))
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image,
+// defaulting to 1 if the image carries none.
+func jpegOrientation(b []byte) int {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(b); {
+		if b[i] != 0xFF {
+			return 1
+		}
+
+		marker := b[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(b[i+2:]))
+		if length < 2 || i+2+length > len(b) {
+			return 1
+		}
+
+		segment := b[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+
+		i += 2 + length
+	}
+
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a
+// TIFF structured EXIF payload.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 0 || offset+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value < 1 || value > 8 {
+				return 1
+			}
+			return value
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,158 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+	"os"
+	"tc-server/config"
+)
+
+// TokenKey is a key used to sign and verify tokens, identified by the
+// kid header of every token it signs. Asymmetric keys verify using their
+// public half so it can be published, HMAC keys use the shared secret.
+type TokenKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+// NewHMACTokenKey creates an HS256 token key from a shared secret.
+func NewHMACTokenKey(id string, secret string) *TokenKey {
+	return &TokenKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		SignKey:   []byte(secret),
+		VerifyKey: []byte(secret),
+	}
+}
+
+// LoadHMACTokenKey creates an HS256 token key from a configured secret.
+// An empty secret is rejected since anyone could sign tokens with it.
+func LoadHMACTokenKey(id string, secret string) (*TokenKey, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret of key %q is not configured", id)
+	}
+
+	return NewHMACTokenKey(id, secret), nil
+}
+
+// LoadTokenKey reads a PEM encoded private key from disk.
+func LoadTokenKey(id string, path string) (*TokenKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTokenKey(id, b)
+}
+
+// ParseTokenKey parses a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key.
+// RSA keys sign using RS256, Ed25519 keys using EdDSA and P-256 keys
+// using ES256. A PKIX public key may be provided for keys which only
+// verify tokens, in which case the key has no SignKey.
+func ParseTokenKey(id string, b []byte) (*TokenKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found for key %q", id)
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %q: %w", id, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &TokenKey{ID: id, Method: jwt.SigningMethodRS256, SignKey: key, VerifyKey: &key.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &TokenKey{ID: id, Method: jwt.SigningMethodRS256, VerifyKey: key}, nil
+	case ed25519.PrivateKey:
+		return &TokenKey{ID: id, Method: jwt.SigningMethodEdDSA, SignKey: key, VerifyKey: key.Public()}, nil
+	case ed25519.PublicKey:
+		return &TokenKey{ID: id, Method: jwt.SigningMethodEdDSA, VerifyKey: key}, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve for key %q, expected P-256", id)
+		}
+		return &TokenKey{ID: id, Method: jwt.SigningMethodES256, SignKey: key, VerifyKey: &key.PublicKey}, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve for key %q, expected P-256", id)
+		}
+		return &TokenKey{ID: id, Method: jwt.SigningMethodES256, VerifyKey: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T for key %q", parsed, id)
+	}
+}
+
+// NewRefreshTokenKey returns the refresh token key. Refresh tokens are
+// only ever verified by this service and thus keep using the shared
+// refresh token secret.
+func NewRefreshTokenKey(conf *config.AuthConfig) (*TokenKey, error) {
+	return LoadHMACTokenKey("refresh", conf.RefreshTokenPub)
+}
+
+// NewMFATokenKey returns the key of the token issued during a login
+// step-up. It is kept apart from the access token keys, which are
+// published, so the token can never pass as an access token.
+func NewMFATokenKey(conf *config.AuthConfig) (*TokenKey, error) {
+	return LoadHMACTokenKey("mfa", conf.MFATokenKey)
+}
+
+// JWK returns the public key in JSON Web Key format. HMAC keys have no
+// public representation, in which case false is returned.
+func (k *TokenKey) JWK() (map[string]string, bool) {
+	encode := base64.RawURLEncoding.EncodeToString
+	jwk := map[string]string{
+		"kid": k.ID,
+		"alg": k.Method.Alg(),
+		"use": "sig",
+	}
+
+	switch key := k.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = encode(key.N.Bytes())
+		jwk["e"] = encode(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = encode(key)
+	case *ecdsa.PublicKey:
+		jwk["kty"] = "EC"
+		jwk["crv"] = "P-256"
+		jwk["x"] = encode(key.X.FillBytes(make([]byte, 32)))
+		jwk["y"] = encode(key.Y.FillBytes(make([]byte, 32)))
+	default:
+		return nil, false
+	}
+
+	return jwk, true
+}
+
+// Key returns the token key itself if kid references it. Tokens without
+// a kid header, issued before key IDs were introduced, resolve to the key
+// as well.
+func (k *TokenKey) Key(kid string) (*TokenKey, bool) {
+	return k, len(kid) == 0 || kid == k.ID
+}
@@ -0,0 +1,190 @@
+package util
+
+import (
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"sort"
+	"tc-server/config"
+	"time"
+)
+
+// KeySet resolves the key a token was signed with from its kid header.
+type KeySet interface {
+	Key(kid string) (*TokenKey, bool)
+}
+
+// Keyring holds the current signing key together with retired keys that
+// still verify tokens issued before their retirement. A retired key is
+// only trusted until its retirement window, the lifetime of the tokens it
+// signed, has passed. After that it can be removed from the config.
+type Keyring struct {
+	Current *TokenKey
+	Window  time.Duration
+
+	keys    map[string]*TokenKey
+	retired map[string]time.Time
+}
+
+// NewKeyring creates a keyring signing with current.
+func NewKeyring(current *TokenKey, window time.Duration) *Keyring {
+	return &Keyring{
+		Current: current,
+		Window:  window,
+		keys:    map[string]*TokenKey{current.ID: current},
+		retired: make(map[string]time.Time),
+	}
+}
+
+// Retire adds a verification key which was retired at the provided time.
+func (k *Keyring) Retire(key *TokenKey, retiredAt time.Time) {
+	k.keys[key.ID] = key
+	k.retired[key.ID] = retiredAt
+}
+
+// Key returns the key matching kid, as long as it is the current key or
+// a retired key within its retirement window. Tokens without a kid header
+// resolve to the current key.
+func (k *Keyring) Key(kid string) (*TokenKey, bool) {
+	if len(kid) == 0 {
+		return k.Current, true
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, false
+	}
+
+	if retiredAt, ok := k.retired[kid]; ok && time.Now().After(retiredAt.Add(k.Window)) {
+		return nil, false
+	}
+
+	return key, true
+}
+
+// VerificationKeys returns every key currently trusted to verify tokens,
+// starting with the current key.
+func (k *Keyring) VerificationKeys() []*TokenKey {
+	keys := []*TokenKey{k.Current}
+	for _, id := range k.sortedRetired() {
+		if key, ok := k.Key(id); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// Removable returns the IDs of retired keys whose retirement window has
+// passed. These keys are no longer trusted and can be deleted.
+func (k *Keyring) Removable() []string {
+	var ids []string
+	for _, id := range k.sortedRetired() {
+		if _, ok := k.Key(id); !ok {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+func (k *Keyring) sortedRetired() []string {
+	ids := make([]string, 0, len(k.retired))
+	for id := range k.retired {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// NewAccessTokenKeyring builds the access token keyring from the auth
+// configuration. The key matching AccessTokenKeyID signs new tokens, all
+// other configured keys must carry a retirement time and only verify.
+// Without a configured keyring the single AccessTokenKeyFile key, or the
+// AccessTokenPub secret, is used. HMAC keys without a secret are
+// rejected.
+func NewAccessTokenKeyring(conf *config.AuthConfig) (*Keyring, error) {
+	window := time.Duration(conf.AccessTokenTTL) * time.Minute
+
+	id := conf.AccessTokenKeyID
+	if len(id) == 0 {
+		id = "access"
+	}
+
+	if len(conf.AccessTokenKeys) == 0 {
+		if len(conf.AccessTokenKeyFile) == 0 {
+			key, err := LoadHMACTokenKey(id, conf.AccessTokenPub)
+			if err != nil {
+				return nil, err
+			}
+
+			return NewKeyring(key, window), nil
+		}
+
+		key, err := LoadTokenKey(id, conf.AccessTokenKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewKeyring(key, window), nil
+	}
+
+	var current *TokenKey
+	retired := make(map[*TokenKey]time.Time)
+	for _, kc := range conf.AccessTokenKeys {
+		var key *TokenKey
+		var err error
+		if len(kc.File) > 0 {
+			key, err = LoadTokenKey(kc.ID, kc.File)
+		} else {
+			key, err = LoadHMACTokenKey(kc.ID, kc.Secret)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if kc.ID == id {
+			current = key
+			continue
+		}
+
+		retiredAt, err := time.Parse(time.RFC3339, kc.RetiredAt)
+		if err != nil {
+			return nil, fmt.Errorf("retired key %q requires an RFC 3339 retired_at: %w", kc.ID, err)
+		}
+		retired[key] = retiredAt
+	}
+
+	if current == nil {
+		return nil, fmt.Errorf("current access token key %q is not part of the keyring", id)
+	}
+
+	if current.SignKey == nil {
+		return nil, fmt.Errorf("current access token key %q has no private key", id)
+	}
+
+	keyring := NewKeyring(current, window)
+	for key, retiredAt := range retired {
+		keyring.Retire(key, retiredAt)
+	}
+
+	return keyring, nil
+}
+
+// keyFunc returns a jwt.Keyfunc resolving the verification key of a
+// parsed token from the key set, rejecting unknown keys and tokens signed
+// with a different algorithm than their key.
+func keyFunc(keys KeySet) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown token key %v", token.Header["kid"])
+		}
+
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("invalid token format %v", token.Header["alg"])
+		}
+
+		return key.VerifyKey, nil
+	}
+}
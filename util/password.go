@@ -0,0 +1,190 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"tc-server/config"
+)
+
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+)
+
+// ErrUnknownPasswordHash is returned when a stored password hash uses
+// neither the argon2id PHC format nor bcrypt.
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// verifies passwords against argon2id and bcrypt hashes. argon2id hashes
+// are encoded in the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type PasswordHasher struct {
+	algorithm string
+	argon2    config.Argon2Config
+	cost      int
+	dummy     string
+}
+
+// NewPasswordHasher creates a PasswordHasher from the provided config,
+// falling back to argon2id with OWASP recommended parameters for any
+// value left empty.
+func NewPasswordHasher(conf *config.PasswordHashingConfig) (*PasswordHasher, error) {
+	h := &PasswordHasher{
+		algorithm: conf.Algorithm,
+		argon2:    conf.Argon2,
+		cost:      conf.BcryptCost,
+	}
+
+	if len(h.algorithm) == 0 {
+		h.algorithm = PasswordAlgorithmArgon2id
+	}
+
+	if h.algorithm != PasswordAlgorithmArgon2id && h.algorithm != PasswordAlgorithmBcrypt {
+		return nil, fmt.Errorf("unsupported password hashing algorithm %q", h.algorithm)
+	}
+
+	if h.argon2.Memory == 0 {
+		h.argon2.Memory = 19456
+	}
+	if h.argon2.Iterations == 0 {
+		h.argon2.Iterations = 2
+	}
+	if h.argon2.Parallelism == 0 {
+		h.argon2.Parallelism = 1
+	}
+	if h.argon2.SaltLength == 0 {
+		h.argon2.SaltLength = 16
+	}
+	if h.argon2.KeyLength == 0 {
+		h.argon2.KeyLength = 32
+	}
+	if h.cost == 0 {
+		h.cost = 12
+	}
+
+	if h.cost < bcrypt.MinCost || h.cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	dummy, err := h.Hash("training-club-dummy-password")
+	if err != nil {
+		return nil, err
+	}
+
+	h.dummy = dummy
+	return h, nil
+}
+
+// Hash hashes the password with the configured algorithm.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.algorithm == PasswordAlgorithmBcrypt {
+		// bcrypt ignores everything past 72 bytes, which GenerateFromPassword
+		// rejects instead of truncating silently.
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hash), nil
+	}
+
+	salt := make([]byte, h.argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := h.argon2
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether the password matches the encoded hash and
+// whether the hash should be replaced because it uses a different
+// algorithm or weaker parameters than the ones configured.
+func (h *PasswordHasher) Verify(password, encoded string) (ok bool, rehash bool, err error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		p, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false, err
+		}
+
+		candidate := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(candidate, key) != 1 {
+			return false, false, nil
+		}
+
+		rehash = h.algorithm != PasswordAlgorithmArgon2id ||
+			p.Memory < h.argon2.Memory || p.Iterations < h.argon2.Iterations ||
+			p.Parallelism < h.argon2.Parallelism || uint32(len(key)) < h.argon2.KeyLength ||
+			uint32(len(salt)) < h.argon2.SaltLength
+		return true, rehash, nil
+	}
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, ErrUnknownPasswordHash
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	}
+
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, h.algorithm != PasswordAlgorithmBcrypt || cost < h.cost, nil
+}
+
+// VerifyDummy runs a comparison against a throwaway hash so callers can
+// spend the same time on unknown accounts as on known ones.
+func (h *PasswordHasher) VerifyDummy(password string) {
+	_, _, _ = h.Verify(password, h.dummy)
+}
+
+func decodeArgon2id(encoded string) (config.Argon2Config, []byte, []byte, error) {
+	var p config.Argon2Config
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+
+	return p, salt, key, nil
+}
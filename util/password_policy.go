@@ -0,0 +1,234 @@
+package util
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"tc-server/config"
+	"unicode/utf8"
+)
+
+// Reasons a password is rejected by a PasswordPolicy.
+const (
+	PasswordTooShort         = "too_short"
+	PasswordTooLong          = "too_long"
+	PasswordCommon           = "common"
+	PasswordContainsIdentity = "contains_identity"
+	PasswordBreached         = "breached"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	defaultPasswordMaxLength = 128
+
+	// minIdentityLength is the shortest identity checked for in a
+	// password, shorter ones would reject unrelated passwords.
+	minIdentityLength = 3
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// PasswordRejection is a single reason a password was rejected, the code
+// is stable for clients and the message is human readable.
+type PasswordRejection struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy screens new passwords against length limits, a list of
+// common passwords, the identity of the account and a local copy of a
+// breached password dataset.
+//
+// The breached dataset is queried by k-anonymity prefix: the directory
+// holds one file per upper case 5 character SHA-1 prefix, each listing
+// the remaining 35 characters of breached hashes as "SUFFIX:COUNT", the
+// format served by the Pwned Passwords range API.
+type PasswordPolicy struct {
+	minLength int
+	maxLength int
+	common    map[string]struct{}
+	breaches  string
+}
+
+// NewPasswordPolicy creates a PasswordPolicy from the provided config.
+// The built-in common password list is extended with the configured
+// list, if any.
+func NewPasswordPolicy(conf *config.PasswordPolicyConfig) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		minLength: conf.MinLength,
+		maxLength: conf.MaxLength,
+		common:    map[string]struct{}{},
+		breaches:  conf.BreachDirectory,
+	}
+
+	if p.minLength == 0 {
+		p.minLength = defaultPasswordMinLength
+	}
+	if p.maxLength == 0 {
+		p.maxLength = defaultPasswordMaxLength
+	}
+
+	if p.minLength > p.maxLength {
+		return nil, errors.New("password min length exceeds max length")
+	}
+
+	if err := p.addCommon(strings.NewReader(commonPasswords)); err != nil {
+		return nil, err
+	}
+
+	if len(conf.CommonPasswordsFile) > 0 {
+		file, err := os.Open(conf.CommonPasswordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open common password list: %w", err)
+		}
+		defer file.Close()
+
+		if err := p.addCommon(file); err != nil {
+			return nil, fmt.Errorf("failed to read common password list: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// Validate returns every reason the password violates the policy, none
+// if it is acceptable. Identities are values the password must not
+// contain, such as the username and email of the account.
+func (p *PasswordPolicy) Validate(password string, identities ...string) ([]PasswordRejection, error) {
+	rejections := []PasswordRejection{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		rejections = append(rejections, PasswordRejection{
+			Code:    PasswordTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters", p.minLength),
+		})
+	}
+
+	if length > p.maxLength {
+		rejections = append(rejections, PasswordRejection{
+			Code:    PasswordTooLong,
+			Message: fmt.Sprintf("password must be at most %d characters", p.maxLength),
+		})
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := p.common[lower]; ok {
+		rejections = append(rejections, PasswordRejection{
+			Code:    PasswordCommon,
+			Message: "password is too common",
+		})
+	}
+
+	if containsIdentity(lower, identities) {
+		rejections = append(rejections, PasswordRejection{
+			Code:    PasswordContainsIdentity,
+			Message: "password must not contain your username or email",
+		})
+	}
+
+	count, err := p.BreachCount(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		rejections = append(rejections, PasswordRejection{
+			Code:    PasswordBreached,
+			Message: "password has appeared in a data breach",
+		})
+	}
+
+	return rejections, nil
+}
+
+// BreachCount returns how often the password appears in the breached
+// password dataset. Zero is returned if no dataset is configured.
+func (p *PasswordPolicy) BreachCount(password string) (int, error) {
+	if len(p.breaches) == 0 {
+		return 0, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(p.breaches, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to open breached password range: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate, count, found := strings.Cut(line, ":")
+		if !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+
+		if !found {
+			return 1, nil
+		}
+
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 1, nil
+		}
+
+		return n, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read breached password range: %w", err)
+	}
+
+	return 0, nil
+}
+
+func (p *PasswordPolicy) addCommon(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if len(line) > 0 {
+			p.common[line] = struct{}{}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// containsIdentity reports whether the lower case password contains one
+// of the identities. Emails are checked by their local part.
+func containsIdentity(password string, identities []string) bool {
+	for _, identity := range identities {
+		identity = strings.ToLower(identity)
+		if local, _, ok := strings.Cut(identity, "@"); ok {
+			identity = local
+		}
+
+		if utf8.RuneCountInString(identity) < minIdentityLength {
+			continue
+		}
+
+		if strings.Contains(password, identity) {
+			return true
+		}
+	}
+
+	return false
+}
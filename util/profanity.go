@@ -0,0 +1,50 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// profanity is a list of words rejected in user provided display
+// values. Words are matched against whole, normalized tokens to avoid
+// rejecting innocent names that merely contain one of them.
+var profanity = map[string]bool{
+	"arse": true, "arsehole": true, "ass": true, "asshole": true,
+	"bastard": true, "bitch": true, "bollocks": true, "bullshit": true,
+	"cunt": true, "dickhead": true, "fag": true, "faggot": true,
+	"fuck": true, "fucker": true, "fucking": true, "motherfucker": true,
+	"nigga": true, "nigger": true, "piss": true, "prick": true,
+	"pussy": true, "retard": true, "shit": true, "slut": true,
+	"twat": true, "wanker": true, "whore": true,
+}
+
+// leetspeak maps common character substitutions back to letters.
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i',
+}
+
+// ContainsProfanity returns true if any word in the provided string,
+// or the string as a whole with separators removed, is profane.
+func ContainsProfanity(s string) bool {
+	var collapsed strings.Builder
+	var word strings.Builder
+
+	for _, r := range strings.ToLower(s) {
+		if sub, ok := leetspeak[r]; ok {
+			r = sub
+		}
+
+		if unicode.IsLetter(r) {
+			word.WriteRune(r)
+			collapsed.WriteRune(r)
+			continue
+		}
+
+		if profanity[word.String()] {
+			return true
+		}
+		word.Reset()
+	}
+
+	return profanity[word.String()] || profanity[collapsed.String()]
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"time"
+)
+
+// SignExpiring returns a signature binding the value to an expiry, for
+// use in links that must not be forged or used after they expire.
+func SignExpiring(secret string, value string, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires.Unix(), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyExpiring reports whether the signature was created by
+// SignExpiring for the value and expiry and the expiry has not passed.
+func VerifyExpiring(secret string, value string, expires time.Time, signature string) bool {
+	if time.Now().After(expires) {
+		return false
+	}
+
+	expected := SignExpiring(secret, value, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -6,23 +6,55 @@ import (
 )
 
 type Claims struct {
-	AccountID string `json:"accountId"`
+	AccountID      string   `json:"accountId"`
+	FamilyID       string   `json:"fid,omitempty"`
+	Generation     int64    `json:"gen"`
+	RoleGeneration int64    `json:"rgen,omitempty"`
+	Roles          []string `json:"roles,omitempty"`
+	Scopes         []string `json:"scopes,omitempty"`
+	AuthTime       int64    `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(accountId string, publicKey string, ttl int) (string, error) {
-	secret := []byte(publicKey)
+func GenerateToken(accountId string, key *TokenKey, ttl int) (string, error) {
+	return GenerateTokenWithClaims(Claims{AccountID: accountId}, key, ttl)
+}
+
+// GenerateTokenWithClaims signs the provided claims after applying the
+// registered time claims and a unique token ID (jti), unless the claims
+// already carry one. The key ID is written to the kid header so
+// verifiers can select the matching key.
+func GenerateTokenWithClaims(claims Claims, key *TokenKey, ttl int) (string, error) {
+	jti := claims.ID
+	if len(jti) == 0 {
+		id, err := GenerateRandomString(16)
+		if err != nil {
+			return "", err
+		}
+
+		jti = id
+	}
 
-	claims := Claims{
-		accountId,
-		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(ttl) * time.Minute)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-		},
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        jti,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(ttl) * time.Minute)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		NotBefore: jwt.NewNumericDate(time.Now()),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secret)
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.SignKey)
 	return tokenString, err
 }
+
+// AuthenticatedWithin reports whether a sign-in at authTime happened at
+// most maxAge before now. Tokens issued without an auth_time claim carry
+// the zero time, which never does.
+func AuthenticatedWithin(authTime time.Time, now time.Time, maxAge time.Duration) bool {
+	if authTime.Unix() <= 0 || authTime.After(now) {
+		return false
+	}
+
+	return now.Sub(authTime) <= maxAge
+}
@@ -1,9 +1,12 @@
 package util
 
 import (
-	"fmt"
 	"github.com/golang-jwt/jwt/v4"
+	"net/url"
 	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // ValidateUsername parses a string input and
@@ -29,9 +32,11 @@ func ValidateUsername(s string) bool {
 
 // ValidateEmail parses a string input and
 // returns true if the provided string is a valid
-// email format.
+// email format. The last label of the domain may
+// contain digits so dotted IPv4 domains such as
+// email@123.123.123.123 are accepted.
 func ValidateEmail(s string) bool {
-	rexp, err := regexp.Compile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+	rexp, err := regexp.Compile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z0-9]{2,}$`)
 	if err != nil {
 		return false
 	}
@@ -39,24 +44,55 @@ func ValidateEmail(s string) bool {
 	return rexp.MatchString(s)
 }
 
-// ValidatePassword parses a string input and
-// returns true if the provided string is a vlid
-// password format.
-func ValidatePassword(s string) bool {
-	if len(s) < 6 || len(s) > 32 {
-		return false
+// ValidateToken parses an encoded token (assumed to be a JWT signed by this service)
+// and will return it as a converted jwt token object. The verification key
+// is selected from the key set by the kid header of the token and must
+// match the signing algorithm of the token.
+func ValidateToken(encoded string, keys KeySet) (*jwt.Token, error) {
+	return jwt.Parse(encoded, keyFunc(keys))
+}
+
+// ValidateTokenClaims parses and validates an encoded token in the same
+// manner as ValidateToken, decoding the payload into Claims.
+func ValidateTokenClaims(encoded string, keys KeySet) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(encoded, &claims, keyFunc(keys))
+	if err != nil {
+		return nil, err
 	}
 
-	return true
+	return &claims, nil
 }
 
-// ValidateToken parses an encoded token (assumed to be a JWT signed by this service)
-// and will return it as a converted jwt token object.
-func ValidateToken(encoded string, pubkey string) (*jwt.Token, error) {
-	return jwt.Parse(encoded, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("invalid token format %v", token.Header["alg"])
+// ValidateDisplayName parses a string input and returns true
+// if the provided string is a valid display name. Display names
+// are limited to 32 letters, digits, spaces and . - _ ' characters
+// and may not contain profanity.
+func ValidateDisplayName(s string) bool {
+	if len(s) == 0 || utf8.RuneCountInString(s) > 32 || strings.TrimSpace(s) != s {
+		return false
+	}
+
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) {
+			continue
 		}
-		return []byte(pubkey), nil
-	})
+
+		if !strings.ContainsRune(" .-_'", r) {
+			return false
+		}
+	}
+
+	return !ContainsProfanity(s)
+}
+
+// ValidateURL parses a string input and returns true if the
+// provided string is an absolute http or https URL.
+func ValidateURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
 }
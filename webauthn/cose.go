@@ -0,0 +1,123 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"math/big"
+)
+
+// COSE algorithm identifiers supported for credential public keys.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key parameters, see RFC 9053.
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1 // n for RSA keys
+	coseX         = -2 // e for RSA keys
+	coseY         = -3
+)
+
+// publicKey is a parsed COSE credential public key.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey parses a COSE encoded ES256, EdDSA or RS256 public key.
+func parsePublicKey(b []byte) (*publicKey, error) {
+	var params map[int]interface{}
+	if err := codec.NewDecoderBytes(b, cborHandle).Decode(&params); err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %w", err)
+	}
+
+	alg, _ := coseInt(params[coseAlgorithm])
+	kty, _ := coseInt(params[coseKeyType])
+
+	switch {
+	case alg == AlgES256 && kty == 2:
+		crv, _ := coseInt(params[coseCurve])
+		x, _ := params[coseX].([]byte)
+		y, _ := params[coseY].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 public key")
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("public key is not on curve P-256")
+		}
+
+		return &publicKey{alg: alg, key: key}, nil
+	case alg == AlgEdDSA && kty == 1:
+		crv, _ := coseInt(params[coseCurve])
+		x, _ := params[coseX].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case alg == AlgRS256 && kty == 3:
+		n, _ := params[coseCurve].([]byte)
+		e, _ := params[coseX].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA public key")
+		}
+
+		return &publicKey{alg: alg, key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported public key algorithm %d", alg)
+}
+
+// verify checks the signature over data.
+func (k *publicKey) verify(data []byte, signature []byte) error {
+	hash := sha256.Sum256(data)
+
+	var valid bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, hash[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	}
+
+	if !valid {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
+// coseInt converts a decoded CBOR integer, which may be signed or
+// unsigned depending on its value, to an int64.
+func coseInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case uint64:
+		return int64(n), true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,94 @@
+package webauthn
+
+// The option types mirror the PublicKeyCredentialCreationOptions and
+// PublicKeyCredentialRequestOptions dictionaries passed to
+// navigator.credentials. Binary values are base64url encoded.
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+	Timeout                int                    `json:"timeout"`
+}
+
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+	Timeout          int                    `json:"timeout"`
+}
+
+// CreationOptions returns the options of a registration ceremony for a
+// discoverable credential. Existing credentials of the user are excluded
+// so an authenticator is not registered twice.
+func (rp *RelyingParty) CreationOptions(challenge string, user UserEntity, exclude [][]byte, timeout int) CreationOptions {
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:      user,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+		Timeout:     timeout,
+	}
+}
+
+// RequestOptions returns the options of an assertion ceremony. An empty
+// allow list lets the authenticator choose a discoverable credential.
+func (rp *RelyingParty) RequestOptions(challenge string, allow [][]byte, timeout int) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+		Timeout:          timeout,
+	}
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	res := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, CredentialDescriptor{Type: "public-key", ID: EncodeBase64URL(id)})
+	}
+
+	return res
+}
@@ -0,0 +1,231 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"slices"
+	"strings"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var (
+	// ErrCloned is returned by VerifyAssertion when the signature counter
+	// of an authenticator did not increase, indicating the credential may
+	// have been cloned.
+	ErrCloned = errors.New("signature counter did not increase, credential may be cloned")
+
+	cborHandle = &codec.CborHandle{}
+)
+
+// RelyingParty verifies registration and assertion ceremonies for a
+// single relying party ID.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// Credential is a public key credential created by a registration ceremony.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE encoded public key
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type attestationObject struct {
+	Format    string    `codec:"fmt"`
+	Statement codec.Raw `codec:"attStmt"`
+	AuthData  []byte    `codec:"authData"`
+}
+
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	CredentialID []byte
+	PublicKey    []byte
+}
+
+// NewChallenge returns a new base64url encoded random challenge.
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return EncodeBase64URL(b), nil
+}
+
+// EncodeBase64URL encodes b using unpadded base64url, the encoding used
+// for binary values throughout WebAuthn.
+func EncodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeBase64URL decodes a base64url value with or without padding.
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// VerifyRegistration verifies the response of a registration ceremony
+// started with the provided challenge and returns the created credential.
+// Attestation statements are not verified since the relying party only
+// requests attestation "none".
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON []byte, attestation []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	var obj attestationObject
+	if err := codec.NewDecoderBytes(attestation, cborHandle).Decode(&obj); err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %w", err)
+	}
+
+	data, err := rp.parseAuthenticatorData(obj.AuthData)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Flags&flagAttestedData == 0 || len(data.CredentialID) == 0 {
+		return nil, errors.New("attestation is missing credential data")
+	}
+
+	if _, err := parsePublicKey(data.PublicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        data.CredentialID,
+		PublicKey: data.PublicKey,
+		SignCount: data.SignCount,
+	}, nil
+}
+
+// VerifyAssertion verifies the response of an assertion ceremony started
+// with the provided challenge against a stored credential and returns
+// the new signature counter. ErrCloned is returned if the counter did not
+// increase past the stored one.
+func (rp *RelyingParty) VerifyAssertion(challenge string, publicKey []byte, signCount uint32, clientDataJSON []byte, authData []byte, signature []byte) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	data, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	hash := sha256.Sum256(clientDataJSON)
+	if err := key.verify(append(slices.Clone(authData), hash[:]...), signature); err != nil {
+		return 0, err
+	}
+
+	// Synced passkeys always report a counter of zero, in which case
+	// cloning can not be detected.
+	if (data.SignCount != 0 || signCount != 0) && data.SignCount <= signCount {
+		return 0, ErrCloned
+	}
+
+	return data.SignCount, nil
+}
+
+// verifyClientData checks the ceremony type, challenge and origin of the
+// client data collected by the browser.
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony string, challenge string) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("invalid client data: %w", err)
+	}
+
+	if data.Type != ceremony {
+		return fmt.Errorf("unexpected ceremony type %q", data.Type)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(data.Challenge, "=")), []byte(challenge)) != 1 {
+		return errors.New("challenge mismatch")
+	}
+
+	if !slices.Contains(rp.Origins, data.Origin) {
+		return fmt.Errorf("unexpected origin %q", data.Origin)
+	}
+
+	return nil
+}
+
+// parseAuthenticatorData parses authenticator data and checks it was
+// created for this relying party with the user present and verified.
+func (rp *RelyingParty) parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, errors.New("authenticator data is too short")
+	}
+
+	data := &authenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	expected := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.RPIDHash, expected[:]) {
+		return nil, errors.New("relying party id mismatch")
+	}
+
+	if data.Flags&flagUserPresent == 0 {
+		return nil, errors.New("user was not present")
+	}
+
+	if data.Flags&flagUserVerified == 0 {
+		return nil, errors.New("user was not verified")
+	}
+
+	if data.Flags&flagAttestedData == 0 {
+		return data, nil
+	}
+
+	// Attested credential data consists of the 16 byte AAGUID, the
+	// credential ID length and ID followed by the COSE public key.
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("attested credential data is too short")
+	}
+
+	length := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < length {
+		return nil, errors.New("credential id is too short")
+	}
+
+	data.CredentialID = rest[:length]
+
+	var key codec.Raw
+	if err := codec.NewDecoderBytes(rest[length:], cborHandle).Decode(&key); err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %w", err)
+	}
+
+	data.PublicKey = key
+	return data, nil
+}